@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"doppel/internal/checkpoint"
+	"doppel/internal/progress"
+	"fmt"
+	"os"
+	"time"
+)
+
+const checkpointInterval = time.Minute
+
+// openCheckpoint loads the checkpoint named by --resume or starts the one
+// named by --checkpoint. A resumed checkpoint is saved back to the same file
+// unless --checkpoint names another one. It returns nil when neither flag is
+// set.
+func openCheckpoint() *checkpoint.Checkpoint {
+	if resumePath == "" {
+		if checkpointPath == "" {
+			return nil
+		}
+		return checkpoint.New(checkpointPath)
+	}
+
+	cp, err := checkpoint.Load(resumePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if checkpointPath != "" {
+		cp.SetPath(checkpointPath)
+	}
+	fmt.Printf("Resuming from %s (%d hashes recorded)\n", resumePath, cp.Len())
+	return cp
+}
+
+// hashSession holds what runs alongside the hashing pipeline: the progress
+// reporter and periodic checkpoint saves.
+type hashSession struct {
+	reporter   *progress.Reporter
+	checkpoint *checkpoint.Checkpoint
+	stopSaving func()
+}
+
+func startSession(cp *checkpoint.Checkpoint) *hashSession {
+	s := &hashSession{
+		reporter:   progress.New(os.Stderr, progressMode),
+		checkpoint: cp,
+	}
+	if cp != nil {
+		s.stopSaving = cp.Autosave(checkpointInterval, func(err error) {
+			fmt.Fprintf(os.Stderr, "Warning: could not save checkpoint: %v\n", err)
+		})
+	}
+	return s
+}
+
+// hashError reports a file the pipeline could not hash as intended.
+func (s *hashSession) hashError(path string, err error) {
+	s.reporter.Printf("Warning: %s: %v\n", path, err)
+}
+
+// warnHashError is hashError for commands without progress reporting.
+func warnHashError(path string, err error) {
+	fmt.Fprintf(os.Stderr, "Warning: %s: %v\n", path, err)
+}
+
+// finish stops progress reporting and writes the final checkpoint. It must
+// be called once the pipeline is drained, including after an interrupt.
+func (s *hashSession) finish() {
+	s.reporter.Stop()
+	if s.checkpoint == nil {
+		return
+	}
+
+	s.stopSaving()
+	if err := s.checkpoint.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not save checkpoint: %v\n", err)
+		return
+	}
+	fmt.Printf("Checkpoint saved to %s (%d hashes reused)\n", s.checkpoint.Path(), s.checkpoint.Reused())
+}
@@ -1,7 +1,7 @@
 package cmd
 
 import (
-	"bufio"
+	"context"
 	"doppel/internal/detector"
 	"doppel/internal/hasher"
 	"doppel/internal/scanner"
@@ -29,35 +29,46 @@ var compareCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(compareCmd)
 	compareCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show duplicates without deleting")
-	compareCmd.Flags().Int64Var(&minSize, "min-size", 0, "Ignore files smaller than this size in bytes")
-	compareCmd.Flags().StringSliceVar(&extensions, "extensions", []string{}, "Filter by file extensions (e.g., .jpg,.png)")
+	addFilterFlags(compareCmd)
+	addScanFlags(compareCmd)
+	addHashFlags(compareCmd)
+	addIOFlags(compareCmd)
 	compareCmd.Flags().BoolVar(&deleteFrom1, "delete-from-1", false, "Auto-delete duplicates from directory 1")
 	compareCmd.Flags().BoolVar(&deleteFrom2, "delete-from-2", false, "Auto-delete duplicates from directory 2")
 }
 
 func runCompare(cmd *cobra.Command, args []string) {
+	ctx := cmd.Context()
 	dir1, dir2 := args[0], args[1]
 
 	fmt.Printf("Scanning directory 1: %s\n", dir1)
-	files1, err := scanner.ScanDirectory(dir1)
+	files1, err := scanner.ScanDirectory(ctx, dir1, scanOpts)
+	exitIfInterrupted(ctx)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error scanning directory 1: %v\n", err)
 		os.Exit(1)
 	}
-	files1 = filterFiles(files1)
+	files1, other1 := scanner.SplitCandidates(files1)
+	displaySymlinks(other1)
+	files1 = filters.Apply(files1)
 
 	fmt.Printf("Scanning directory 2: %s\n", dir2)
-	files2, err := scanner.ScanDirectory(dir2)
+	files2, err := scanner.ScanDirectory(ctx, dir2, scanOpts)
+	exitIfInterrupted(ctx)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error scanning directory 2: %v\n", err)
 		os.Exit(1)
 	}
-	files2 = filterFiles(files2)
+	files2, other2 := scanner.SplitCandidates(files2)
+	displaySymlinks(other2)
+	files2 = filters.Apply(files2)
 
-	fmt.Printf("Found %d files in dir1, %d files in dir2, hashing...\n", len(files1), len(files2))
+	fmt.Printf("Found %d files in dir1, %d files in dir2, hashing with %s...\n", len(files1), len(files2), hashAlgorithm.Name())
 
-	hashed1 := hasher.HashFiles(files1, exact)
-	hashed2 := hasher.HashFiles(files2, exact)
+	hashOpts := hasher.Options{Exact: exact, Hasher: hashAlgorithm, Throttle: ioThrottle(), OnError: warnHashError}
+	hashed1 := hasher.HashFiles(ctx, files1, hashOpts)
+	hashed2 := hasher.HashFiles(ctx, files2, hashOpts)
+	exitIfInterrupted(ctx)
 
 	duplicates := findCrossDuplicates(hashed1, hashed2, dir1, dir2)
 
@@ -66,10 +77,10 @@ func runCompare(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	wastedSpace := detector.CalculateWastedSpace(duplicates)
+	wastedSpace := detector.CalculateWastedSpace(duplicates, detector.KeepFirst)
 	fmt.Printf("\nFound %d duplicate groups across directories (%.2f MB duplicated)\n\n", len(duplicates), float64(wastedSpace)/(1024*1024))
 
-	displayCompareDuplicates(duplicates, dir1, dir2)
+	displayCompareDuplicates(ctx, duplicates, dir1, dir2)
 }
 
 func findCrossDuplicates(hashed1, hashed2 []hasher.HashedFile, dir1, dir2 string) []detector.DuplicateGroup {
@@ -95,8 +106,11 @@ func findCrossDuplicates(hashed1, hashed2 []hasher.HashedFile, dir1, dir2 string
 	return duplicates
 }
 
-func displayCompareDuplicates(groups []detector.DuplicateGroup, dir1, dir2 string) {
+func displayCompareDuplicates(ctx context.Context, groups []detector.DuplicateGroup, dir1, dir2 string) {
 	for i, group := range groups {
+		if ctx.Err() != nil {
+			return
+		}
 		similarityTag := ""
 		if group.IsImage {
 			similarityTag = fmt.Sprintf(" ~%d%% similar", group.Similarity)
@@ -146,8 +160,7 @@ func displayCompareDuplicates(groups []detector.DuplicateGroup, dir1, dir2 strin
 		}
 
 		fmt.Print("\nDelete from [1/2/skip]: ")
-		reader := bufio.NewReader(os.Stdin)
-		input, _ := reader.ReadString('\n')
+		input, _ := readLine(ctx)
 		input = strings.TrimSpace(input)
 
 		switch input {
@@ -165,7 +178,7 @@ func displayCompareDuplicates(groups []detector.DuplicateGroup, dir1, dir2 strin
 
 func deleteCompareFiles(files []scanner.FileInfo, dirName string) {
 	for _, file := range files {
-		if err := os.Remove(file.Path); err != nil {
+		if err := uninterruptible(func() error { return os.Remove(file.Path) }); err != nil {
 			fmt.Printf("  ✗ %s: %v\n", file.Path, err)
 		} else {
 			fmt.Printf("  ✓ Deleted from %s: %s\n", dirName, file.Path)
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"doppel/internal/detector"
+	"doppel/internal/hasher"
+	"doppel/internal/scanner"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+var minOverlap int
+
+var dirsCmd = &cobra.Command{
+	Use:   "dirs [directory...]",
+	Short: "Find directories with largely overlapping content",
+	Long: `Find directories with largely overlapping content.
+
+Compares the files directly inside each directory by content hash and lists
+pairs whose Jaccard overlap is at least --min-overlap percent, together with
+the files unique to each side.`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  runDirs,
+}
+
+func init() {
+	rootCmd.AddCommand(dirsCmd)
+	dirsCmd.Flags().IntVar(&minOverlap, "min-overlap", 90, "Minimum content overlap in percent (0-100)")
+	addFilterFlags(dirsCmd)
+	addScanFlags(dirsCmd)
+	addHashFlags(dirsCmd)
+	addIOFlags(dirsCmd)
+}
+
+func runDirs(cmd *cobra.Command, args []string) {
+	if minOverlap < 0 || minOverlap > 100 {
+		fmt.Fprintln(os.Stderr, "Error: --min-overlap must be between 0 and 100")
+		os.Exit(1)
+	}
+
+	ctx := cmd.Context()
+	files, _, err := scanner.ScanDirectories(ctx, args, scanOpts)
+	exitIfInterrupted(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	files, other := scanner.SplitCandidates(files)
+	displaySymlinks(other)
+	files = filters.Apply(files)
+	fmt.Printf("Found %d files, hashing with %s...\n", len(files), hashAlgorithm.Name())
+
+	hashed := hasher.HashFiles(ctx, files, hasher.Options{Exact: true, Hasher: hashAlgorithm, Throttle: ioThrottle(), OnError: warnHashError})
+	exitIfInterrupted(ctx)
+	overlaps := detector.FindSimilarDirs(files, hashed, minOverlap)
+
+	if len(overlaps) == 0 {
+		fmt.Println("No overlapping directories found!")
+		return
+	}
+
+	fmt.Printf("\nFound %d directory pairs with at least %d%% overlap\n", len(overlaps), minOverlap)
+
+	for i, o := range overlaps {
+		fmt.Printf("\nPair %d (%d%% overlap, %d shared files, %.2f MB shared):\n", i+1, o.Overlap, o.SharedCount, float64(o.SharedBytes)/(1024*1024))
+
+		table := tablewriter.NewTable(os.Stdout)
+		table.Header("#", "Directory", "Unique Files")
+		_ = table.Append("[1]", o.Dir1, fmt.Sprintf("%d", len(o.Only1)))
+		_ = table.Append("[2]", o.Dir2, fmt.Sprintf("%d", len(o.Only2)))
+		_ = table.Render()
+
+		if len(o.Only1) == 0 && len(o.Only2) == 0 {
+			continue
+		}
+
+		unique := tablewriter.NewTable(os.Stdout)
+		unique.Header("Only In", "Filename", "Size (MB)")
+		for _, file := range o.Only1 {
+			_ = unique.Append("[1]", filepath.Base(file.Path), fmt.Sprintf("%.2f", float64(file.Size)/(1024*1024)))
+		}
+		for _, file := range o.Only2 {
+			_ = unique.Append("[2]", filepath.Base(file.Path), fmt.Sprintf("%.2f", float64(file.Size)/(1024*1024)))
+		}
+		_ = unique.Render()
+	}
+}
@@ -0,0 +1,217 @@
+package cmd
+
+import (
+	"doppel/internal/filter"
+	"doppel/internal/hasher"
+	"doppel/internal/scanner"
+	"doppel/internal/throttle"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var filters filter.Options
+
+// sizeValue is a flag holding a byte count written as "1048576", "10MB" or
+// "4GiB".
+type sizeValue struct {
+	bytes *int64
+	raw   string
+}
+
+func (v *sizeValue) Set(s string) error {
+	bytes, err := filter.ParseSize(s)
+	if err != nil {
+		return err
+	}
+	*v.bytes = bytes
+	v.raw = s
+	return nil
+}
+
+func (v *sizeValue) String() string {
+	if v.raw == "" {
+		return fmt.Sprintf("%d", *v.bytes)
+	}
+	return v.raw
+}
+
+func (v *sizeValue) Type() string { return "size" }
+
+// rateValue is a flag holding bytes per second written as "50MB/s"; the
+// "/s" is optional.
+type rateValue struct {
+	sizeValue
+}
+
+func (v *rateValue) Set(s string) error {
+	if err := v.sizeValue.Set(strings.TrimSuffix(strings.TrimSpace(s), "/s")); err != nil {
+		return err
+	}
+	v.raw = s
+	return nil
+}
+
+func (v *rateValue) Type() string { return "rate" }
+
+// timeValue is a flag holding a point in time written as an age ("30d") or
+// a date ("2024-01-01").
+type timeValue struct {
+	t   *time.Time
+	raw string
+}
+
+func (v *timeValue) Set(s string) error {
+	t, err := filter.ParseTime(s, time.Now())
+	if err != nil {
+		return err
+	}
+	*v.t = t
+	v.raw = s
+	return nil
+}
+
+func (v *timeValue) String() string { return v.raw }
+
+func (v *timeValue) Type() string { return "time" }
+
+func addFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().Var(&sizeValue{bytes: &filters.MinSize}, "min-size", "Ignore files smaller than this size (e.g. 4096, 10MB, 1.5GiB)")
+	cmd.Flags().Var(&sizeValue{bytes: &filters.MaxSize}, "max-size", "Ignore files larger than this size (e.g. 4GiB)")
+	cmd.Flags().Var(&timeValue{t: &filters.NewerThan}, "newer-than", "Only include files modified after this age or date (e.g. 30d, 2024-01-01)")
+	cmd.Flags().Var(&timeValue{t: &filters.OlderThan}, "older-than", "Only include files modified before this age or date (e.g. 1y, 2024-01-01)")
+	cmd.Flags().StringSliceVar(&filters.Extensions, "extensions", []string{}, "Filter by file extensions (e.g., .jpg,.png)")
+}
+
+// enumValue is a flag that only accepts one of a fixed set of values.
+type enumValue[T ~string] struct {
+	value   *T
+	allowed []T
+}
+
+func newEnumValue[T ~string](value *T, def T, allowed []T) *enumValue[T] {
+	*value = def
+	return &enumValue[T]{value: value, allowed: allowed}
+}
+
+func (v *enumValue[T]) Set(s string) error {
+	for _, allowed := range v.allowed {
+		if string(allowed) == s {
+			*v.value = allowed
+			return nil
+		}
+	}
+	return fmt.Errorf("must be one of %s", v.Type())
+}
+
+func (v *enumValue[T]) String() string { return string(*v.value) }
+
+func (v *enumValue[T]) Type() string {
+	names := make([]string, len(v.allowed))
+	for i, allowed := range v.allowed {
+		names[i] = string(allowed)
+	}
+	return strings.Join(names, "|")
+}
+
+var scanOpts scanner.Options
+
+func addScanFlags(cmd *cobra.Command) {
+	cmd.Flags().Var(newEnumValue(&scanOpts.Symlinks, scanner.SymlinksSkip, scanner.SymlinkPolicies), "symlinks", "How to treat symlinks: skip them, follow them, or report them without following")
+	cmd.Flags().BoolVar(&scanOpts.OneFileSystem, "one-file-system", false, "Stay on the file system of each directory and skip mount points below it")
+	cmd.Flags().IntVar(&scanOpts.MaxDepth, "max-depth", 0, "Only descend this many directory levels (1 = the directory's own files, 0 = unlimited)")
+}
+
+var (
+	maxReadRate int64
+	maxIOPS     int
+	idleIO      bool
+)
+
+func addIOFlags(cmd *cobra.Command) {
+	cmd.Flags().Var(&rateValue{sizeValue{bytes: &maxReadRate}}, "max-read-rate", "Limit reading for hashing to this many bytes per second (e.g. 50MB/s)")
+	cmd.Flags().IntVar(&maxIOPS, "max-iops", 0, "Limit reading for hashing to this many read operations per second")
+	cmd.Flags().BoolVar(&idleIO, "idle-io", false, "Only use disk time no other process wants (Linux idle IO priority)")
+}
+
+// ioThrottle applies --idle-io and returns the read limits for the hasher.
+func ioThrottle() *throttle.Throttle {
+	if idleIO {
+		if err := throttle.SetIdlePriority(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not lower IO priority: %v\n", err)
+		}
+	}
+	return throttle.New(maxReadRate, maxIOPS)
+}
+
+// hasherValue is a flag naming a content hash algorithm.
+type hasherValue struct {
+	hasher *hasher.Hasher
+}
+
+func (v *hasherValue) Set(s string) error {
+	h, err := hasher.HasherByName(s)
+	if err != nil {
+		return err
+	}
+	*v.hasher = h
+	return nil
+}
+
+func (v *hasherValue) String() string { return (*v.hasher).Name() }
+
+func (v *hasherValue) Type() string {
+	names := make([]string, len(hasher.Hashers))
+	for i, h := range hasher.Hashers {
+		names[i] = h.Name()
+	}
+	return strings.Join(names, "|")
+}
+
+var hashAlgorithm = hasher.SHA256
+
+// phashValue is a flag holding one perceptual hash algorithm, or several
+// separated by commas that must all agree.
+type phashValue struct {
+	algorithms *[]hasher.PHashAlgorithm
+}
+
+func (v *phashValue) Set(s string) error {
+	var algorithms []hasher.PHashAlgorithm
+	for _, name := range strings.Split(s, ",") {
+		algorithm := hasher.PHashAlgorithm(strings.TrimSpace(name))
+		if !slices.Contains(hasher.PHashAlgorithms, algorithm) {
+			return fmt.Errorf("unknown perceptual hash %q, must be one of %s", name, v.Type())
+		}
+		if slices.Contains(algorithms, algorithm) {
+			return fmt.Errorf("perceptual hash %q is listed twice", name)
+		}
+		algorithms = append(algorithms, algorithm)
+	}
+	*v.algorithms = algorithms
+	return nil
+}
+
+func (v *phashValue) String() string {
+	names := make([]string, len(*v.algorithms))
+	for i, algorithm := range *v.algorithms {
+		names[i] = string(algorithm)
+	}
+	return strings.Join(names, ",")
+}
+
+func (v *phashValue) Type() string {
+	names := make([]string, len(hasher.PHashAlgorithms))
+	for i, algorithm := range hasher.PHashAlgorithms {
+		names[i] = string(algorithm)
+	}
+	return strings.Join(names, "|")
+}
+
+func addHashFlags(cmd *cobra.Command) {
+	cmd.Flags().Var(&hasherValue{hasher: &hashAlgorithm}, "hash", "Content hash: sha256, blake3 (several times faster) or xxh3 (fastest, not cryptographic)")
+}
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// exitInterrupted is the conventional exit status after SIGINT.
+const exitInterrupted = 130
+
+var interrupts struct {
+	mu      sync.Mutex
+	busy    int
+	exiting bool
+}
+
+// handleInterrupts returns a context that is cancelled by the first SIGINT or
+// SIGTERM, so scanning and hashing wind down and partial results can still be
+// used. A second signal exits right away, unless a delete is in progress, in
+// which case the exit waits for it to finish.
+func handleInterrupts(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case <-signals:
+		case <-ctx.Done():
+			return
+		}
+		fmt.Fprintln(os.Stderr, "\nInterrupted, stopping (press Ctrl-C again to quit)")
+		cancel()
+
+		<-signals
+		interrupts.mu.Lock()
+		defer interrupts.mu.Unlock()
+		if interrupts.busy > 0 {
+			interrupts.exiting = true
+			return
+		}
+		os.Exit(exitInterrupted)
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
+
+// exitIfInterrupted ends a command whose results would be misleading if
+// only partly computed.
+func exitIfInterrupted(ctx context.Context) {
+	if ctx.Err() != nil {
+		fmt.Fprintln(os.Stderr, "Interrupted")
+		os.Exit(exitInterrupted)
+	}
+}
+
+// uninterruptible runs an operation that must not be cut short, such as
+// removing a file or directory tree. Signals that arrive meanwhile take
+// effect once it returns.
+func uninterruptible(fn func() error) error {
+	interrupts.mu.Lock()
+	interrupts.busy++
+	interrupts.mu.Unlock()
+
+	defer func() {
+		interrupts.mu.Lock()
+		interrupts.busy--
+		exit := interrupts.busy == 0 && interrupts.exiting
+		interrupts.mu.Unlock()
+		if exit {
+			os.Exit(exitInterrupted)
+		}
+	}()
+
+	return fn()
+}
+
+var (
+	stdinOnce  sync.Once
+	stdinLines chan string
+)
+
+// readLine reads one line of input for a prompt. It returns false when ctx
+// is cancelled first or stdin is closed. A line typed after an abandoned
+// prompt goes to the next one.
+func readLine(ctx context.Context) (string, bool) {
+	stdinOnce.Do(func() {
+		stdinLines = make(chan string)
+		go func() {
+			defer close(stdinLines)
+			reader := bufio.NewReader(os.Stdin)
+			for {
+				line, err := reader.ReadString('\n')
+				if line != "" || err == nil {
+					stdinLines <- line
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+	})
+
+	select {
+	case line, ok := <-stdinLines:
+		return line, ok
+	case <-ctx.Done():
+		return "", false
+	}
+}
@@ -1,14 +1,20 @@
 package cmd
 
 import (
-	"bufio"
+	"context"
+	"doppel/internal/checkpoint"
 	"doppel/internal/detector"
 	"doppel/internal/hasher"
+	"doppel/internal/progress"
 	"doppel/internal/scanner"
 	"doppel/internal/updater"
+	"errors"
 	"fmt"
+	"io"
+	"iter"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/olekukonko/tablewriter"
@@ -22,36 +28,90 @@ var (
 )
 
 var (
-	dryRun     bool
-	autoDelete bool
-	minSize    int64
-	extensions []string
-	showAll    bool
-	exact      bool
-	threshold  int
+	dryRun         bool
+	autoDelete     bool
+	showAll        bool
+	exact          bool
+	threshold      int
+	audioThreshold int
+	findDirs       bool
+	phashAlgos     = []hasher.PHashAlgorithm{hasher.DifferenceHash}
+	invariant      bool
+	audio          bool
+	fingerprint    bool
+	textNormalize  bool
+	collapseBlank  bool
+	classify       hasher.Classify
+	keepPolicy     detector.KeepPolicy
+	showRoots      bool
+	filesFrom      string
+	nullSep        bool
+
+	progressMode   progress.Mode
+	checkpointPath string
+	resumePath     string
 )
 
 var rootCmd = &cobra.Command{
-	Use:   "doppel [directory]",
+	Use:   "doppel [directory...]",
 	Short: "Find and remove duplicate files",
-	Args:  cobra.ExactArgs(1),
+	Args:  rootArgs,
 	Run:   run,
 }
 
 func init() {
 	rootCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show duplicates without deleting")
-	rootCmd.Flags().BoolVar(&autoDelete, "auto-delete", false, "Automatically keep first file and delete others")
+	rootCmd.Flags().BoolVar(&autoDelete, "auto-delete", false, "Automatically keep one file per group, chosen by --keep, and delete the others")
+	rootCmd.Flags().Var(newEnumValue(&keepPolicy, detector.KeepFirst, detector.KeepPolicies), "keep", "File to keep with --auto-delete and --show-all: the first in path order, or the image with the highest resolution")
 	rootCmd.Flags().BoolVar(&showAll, "show-all", false, "Show all duplicates first, then delete with single confirmation")
 	rootCmd.Flags().BoolVar(&exact, "exact", false, "Use exact byte matching for all files (disable perceptual hashing for images)")
-	rootCmd.Flags().IntVar(&threshold, "threshold", 5, "Similarity threshold for images (0-64, lower = more similar)")
-	rootCmd.Flags().Int64Var(&minSize, "min-size", 0, "Ignore files smaller than this size in bytes")
-	rootCmd.Flags().StringSliceVar(&extensions, "extensions", []string{}, "Filter by file extensions (e.g., .jpg,.png)")
+	rootCmd.Flags().IntVar(&threshold, "threshold", 5, "Similarity threshold for images (0-64, lower = more similar; scaled for longer hashes)")
+	rootCmd.Flags().Var(&phashValue{algorithms: &phashAlgos}, "phash", "Perceptual hash for images; list two separated by commas to require both to agree")
+	rootCmd.Flags().Var(newEnumValue(&classify, hasher.ClassifyExtension, hasher.ClassifyModes), "classify", "Tell images, audio and text apart by their extension, their first bytes (content, which opens every file), or either one (both)")
+	rootCmd.Flags().BoolVar(&invariant, "invariant", false, "Also match images that were rotated by 90, 180 or 270 degrees or mirrored")
+	rootCmd.Flags().BoolVar(&audio, "audio", false, "Match MP3 and FLAC files by their audio alone, ignoring ID3, APE and Vorbis tags")
+	rootCmd.Flags().BoolVar(&fingerprint, "fingerprint", false, "Match WAV and FLAC files that sound alike, even at another sample rate or bit depth, by an acoustic fingerprint of their decoded audio")
+	rootCmd.Flags().IntVar(&audioThreshold, "audio-threshold", 10, "Similarity threshold for --fingerprint (0-100, the percentage of fingerprint bits that may differ)")
+	rootCmd.Flags().BoolVar(&textNormalize, "text-normalize", false, "Match text files that only differ in line endings, trailing whitespace, byte order mark or encoding")
+	rootCmd.Flags().BoolVar(&collapseBlank, "collapse-blank-lines", false, "Like --text-normalize, and also ignore how many blank lines separate two lines")
+	rootCmd.Flags().BoolVar(&findDirs, "dirs", false, "Report duplicate directory trees as single groups")
+	rootCmd.Flags().StringVar(&filesFrom, "files-from", "", "Read the files to check from FILE instead of scanning directories (- for stdin)")
+	rootCmd.Flags().BoolVarP(&nullSep, "null", "0", false, "File list entries are separated by NUL instead of newline")
+	rootCmd.Flags().StringVar(&checkpointPath, "checkpoint", "", "Save completed hashes to FILE every minute and at the end, so an interrupted run can be resumed")
+	rootCmd.Flags().StringVar(&resumePath, "resume", "", "Continue the run saved in checkpoint FILE: the directories, by default the saved ones, are walked again, but only files that changed are hashed again")
+	rootCmd.Flags().Var(newEnumValue(&progressMode, progress.Auto, progress.Modes), "progress", "Progress on stderr: a live status line on a terminal and log lines otherwise (auto), either one, or off")
+	addFilterFlags(rootCmd)
+	addScanFlags(rootCmd)
+	addHashFlags(rootCmd)
+	addIOFlags(rootCmd)
+}
+
+func rootArgs(cmd *cobra.Command, args []string) error {
+	if findDirs && !filters.IsEmpty() {
+		return fmt.Errorf("--dirs needs every file of a tree hashed and cannot be used with --min-size, --max-size, --newer-than, --older-than or --extensions")
+	}
+	if filesFrom != "" {
+		if len(args) > 0 {
+			return fmt.Errorf("directories cannot be combined with --files-from")
+		}
+		if findDirs {
+			return fmt.Errorf("--dirs needs complete directory scans and cannot be used with --files-from")
+		}
+		return nil
+	}
+	if resumePath != "" {
+		return nil
+	}
+	return cobra.MinimumNArgs(1)(cmd, args)
 }
 
 func Execute() {
 	checkForUpdatesOnStartup()
 
-	if err := rootCmd.Execute(); err != nil {
+	ctx, stop := handleInterrupts(context.Background())
+	defer stop()
+
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
@@ -71,123 +131,420 @@ func checkForUpdatesOnStartup() {
 }
 
 func run(cmd *cobra.Command, args []string) {
-	directory := args[0]
+	ctx := cmd.Context()
+
+	cp := openCheckpoint()
+	if cp != nil && len(args) == 0 && filesFrom == "" {
+		args = cp.Roots()
+		if len(args) == 0 {
+			fmt.Fprintf(os.Stderr, "Error: checkpoint %s records no directories, pass them again\n", resumePath)
+			os.Exit(1)
+		}
+	}
 
-	files, err := scanner.ScanDirectory(directory)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+	src := &candidateSource{keepAll: findDirs, checkpoint: cp}
+	// Directory digests must account for everything a directory holds.
+	scanOpts.ListAll = findDirs
+	if filesFrom != "" {
+		list, err := readFileList(filesFrom)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		src.list = list
+		src.fromList = true
+	} else {
+		src.roots = scanner.DedupeRoots(args)
+	}
+	cp.SetRoots(src.roots)
+
+	showRoots = len(src.roots) > 1
+	if showRoots {
+		fmt.Printf("Scanning %d directories and hashing with %s...\n", len(src.roots), hashAlgorithm.Name())
+	} else {
+		fmt.Printf("Scanning and hashing with %s...\n", hashAlgorithm.Name())
 	}
 
-	files = filterFiles(files)
-	fmt.Printf("Found %d files, hashing...\n", len(files))
+	session := startSession(cp)
+	src.progress = session.reporter
+
+	hashOpts := hasher.Options{Exact: exact, Hasher: hashAlgorithm, PHash: phashAlgos, Classify: classify, Invariant: invariant, Audio: audio, Fingerprint: fingerprint, TextNormalize: textNormalize || collapseBlank, CollapseBlankLines: collapseBlank, Throttle: ioThrottle()}
+	hashOpts.OnError = session.hashError
+	if session.reporter != nil {
+		hashOpts.Progress = session.reporter
+	}
+	if cp != nil {
+		hashOpts.Cache = cp
+	}
 
-	hashed := hasher.HashFiles(files, exact)
-	duplicates := detector.FindDuplicates(hashed, threshold)
+	var hashed []hasher.HashedFile
+	batches := hasher.HashStream(ctx, src.files(ctx), hashOpts)
+	if findDirs {
+		batches = recordHashed(batches, &hashed)
+	}
+	groups := detector.StreamDuplicates(ctx, batches, threshold, audioThreshold)
+
+	// Groups can be handled one by one while hashing continues, unless
+	// all of them are needed up front.
+	if !findDirs && !showAll {
+		runStreaming(ctx, src, groups, session)
+		return
+	}
+
+	// Batches complete in no particular order; a report shown all at once
+	// is put back in walk order.
+	duplicates := slices.Collect(groups)
+	detector.SortGroups(duplicates)
+	session.finish()
+	src.check()
+	fmt.Printf("Checked %d files\n", src.count)
+
+	interrupted := ctx.Err() != nil
+	if interrupted {
+		if !offerPartial(len(duplicates)) {
+			return
+		}
+		// From here on, a second interrupt quits; deletes still finish.
+		ctx = context.WithoutCancel(ctx)
+	}
+
+	// Directory digests need every file of the tree, which an interrupted
+	// scan cannot promise.
+	var dirGroups []detector.DirectoryGroup
+	if findDirs && !interrupted {
+		dirGroups = detector.FindDuplicateDirs(src.roots, src.scanned, hashed)
+		duplicates = detector.SuppressDirectoryFiles(duplicates, dirGroups)
+	}
 
-	if len(duplicates) == 0 {
+	if len(duplicates) == 0 && len(dirGroups) == 0 {
 		fmt.Println("No duplicates found!")
 		return
 	}
 
-	wastedSpace := detector.CalculateWastedSpace(duplicates)
-	fmt.Printf("\nFound %d duplicate groups (%.2f MB wasted)\n\n", len(duplicates), float64(wastedSpace)/(1024*1024))
+	if len(dirGroups) > 0 {
+		dirWasted := detector.CalculateDirectoryWastedSpace(dirGroups)
+		fmt.Printf("\nFound %d duplicate directory groups (%.2f MB wasted)\n", len(dirGroups), float64(dirWasted)/(1024*1024))
+	}
+
+	if len(duplicates) > 0 {
+		wastedSpace := detector.CalculateWastedSpace(duplicates, keepPolicy)
+		fmt.Printf("\nFound %d duplicate groups (%.2f MB wasted)\n", len(duplicates), float64(wastedSpace)/(1024*1024))
+		displayBreakdown(duplicates)
+	}
+	fmt.Println()
 
-	displayDuplicates(duplicates)
+	displayDuplicates(ctx, dirGroups, duplicates)
 }
 
-func filterFiles(files []scanner.FileInfo) []scanner.FileInfo {
-	if minSize == 0 && len(extensions) == 0 {
-		return files
+func runStreaming(ctx context.Context, src *candidateSource, groups iter.Seq[detector.DuplicateGroup], session *hashSession) {
+	var found []detector.DuplicateGroup
+	for group := range groups {
+		found = append(found, group)
+		session.reporter.Suspend()
+		handleGroup(ctx, len(found), group)
+		session.reporter.Resume()
+		if ctx.Err() != nil {
+			break
+		}
 	}
+	session.finish()
+	src.check()
 
-	filtered := make([]scanner.FileInfo, 0)
-	for _, file := range files {
-		if minSize > 0 && file.Size < minSize {
-			continue
-		}
+	if ctx.Err() != nil {
+		wastedSpace := detector.CalculateWastedSpace(found, keepPolicy)
+		fmt.Printf("\nInterrupted after checking %d files: %d complete duplicate groups shown (%.2f MB wasted)\n", src.count, len(found), float64(wastedSpace)/(1024*1024))
+		os.Exit(exitInterrupted)
+	}
+
+	if len(found) == 0 {
+		fmt.Printf("Checked %d files\n", src.count)
+		fmt.Println("No duplicates found!")
+		return
+	}
+
+	wastedSpace := detector.CalculateWastedSpace(found, keepPolicy)
+	fmt.Printf("\nChecked %d files: %d duplicate groups (%.2f MB wasted)\n", src.count, len(found), float64(wastedSpace)/(1024*1024))
+	displayBreakdown(found)
+}
+
+// breakdownRows is how many extensions and directories are listed in the
+// wasted space breakdown.
+const breakdownRows = 10
+
+// displayBreakdown shows where the wasted space of the groups is: in which
+// kind of group, under which extensions and in which top-level directories.
+func displayBreakdown(groups []detector.DuplicateGroup) {
+	breakdown := detector.BreakDownWastedSpace(groups, keepPolicy)
+	displayShares("group type", "Type", breakdown.Kinds)
+	displayShares("extension", "Extension", breakdown.Extensions)
+	displayShares("top-level directory", "Directory", breakdown.Directories)
+}
+
+func displayShares(title, column string, shares []detector.Share) {
+	if len(shares) == 0 {
+		return
+	}
+
+	fmt.Printf("\nWasted space by %s:\n", title)
+	table := tablewriter.NewTable(os.Stdout)
+	table.Header(column, "Files", "Wasted (MB)")
+	for _, share := range shares[:min(len(shares), breakdownRows)] {
+		_ = table.Append(share.Name, fmt.Sprintf("%d", share.Files), fmt.Sprintf("%.2f", float64(share.Bytes)/(1024*1024)))
+	}
+	_ = table.Render()
+
+	if more := len(shares) - breakdownRows; more > 0 {
+		fmt.Printf("... and %d more\n", more)
+	}
+}
+
+// offerPartial asks whether to go on with the groups that were complete
+// when the run was interrupted. Exact groups are complete once every file of
+// their size is hashed; similar-image groups never are.
+func offerPartial(complete int) bool {
+	if complete == 0 {
+		fmt.Println("Interrupted before any duplicate group was complete")
+		return false
+	}
 
-		if len(extensions) > 0 {
-			ext := strings.ToLower(filepath.Ext(file.Path))
-			found := false
-			for _, allowedExt := range extensions {
-				if strings.ToLower(allowedExt) == ext {
-					found = true
-					break
+	fmt.Printf("\nInterrupted: %d duplicate groups are complete. Show them? [y/N]: ", complete)
+	input, _ := readLine(context.Background())
+	input = strings.ToLower(strings.TrimSpace(input))
+	return input == "y" || input == "yes"
+}
+
+// candidateSource feeds the hashing pipeline with the filtered regular files
+// of a scan or file list. Everything else the scan returns is kept for
+// reporting, and the complete scan is kept as well when keepAll is set. Once
+// the scan completes, the checkpoint forgets files it did not find.
+type candidateSource struct {
+	roots      []string
+	list       []scanner.FileInfo
+	fromList   bool
+	keepAll    bool
+	progress   *progress.Reporter
+	checkpoint *checkpoint.Checkpoint
+
+	scanned []scanner.FileInfo
+	other   []scanner.FileInfo
+	count   int
+	err     error
+}
+
+func (s *candidateSource) files(ctx context.Context) iter.Seq[scanner.FileInfo] {
+	return func(yield func(scanner.FileInfo) bool) {
+		var entries iter.Seq2[scanner.FileInfo, error]
+		if s.fromList {
+			entries = func(yield func(scanner.FileInfo, error) bool) {
+				for _, file := range s.list {
+					if !yield(file, nil) {
+						return
+					}
 				}
 			}
-			if !found {
+		} else {
+			entries = scanner.Walk(ctx, s.roots, scanOpts)
+		}
+
+		for file, err := range entries {
+			if err != nil {
+				s.err = err
+				return
+			}
+			s.progress.FileScanned()
+			if s.keepAll {
+				s.scanned = append(s.scanned, file)
+			}
+			if file.Skipped {
 				continue
 			}
+			if !file.Mode.IsRegular() {
+				s.other = append(s.other, file)
+				continue
+			}
+			s.checkpoint.Seen(file)
+			if !filters.Match(file) {
+				continue
+			}
+			s.count++
+			if !yield(file) {
+				return
+			}
+		}
+		if ctx.Err() == nil {
+			s.checkpoint.Prune()
 		}
+		s.progress.ScanDone()
+	}
+}
 
-		filtered = append(filtered, file)
+// check reports what the scan left out, and exits if it failed. A scan
+// stopped by an interrupt is not a failure. It must only be called once the
+// pipeline is drained.
+func (s *candidateSource) check() {
+	if s.err != nil && !errors.Is(s.err, context.Canceled) {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", s.err)
+		os.Exit(1)
 	}
+	displaySymlinks(s.other)
+}
 
-	return filtered
+func recordHashed(batches iter.Seq[hasher.Batch], hashed *[]hasher.HashedFile) iter.Seq[hasher.Batch] {
+	return func(yield func(hasher.Batch) bool) {
+		for batch := range batches {
+			*hashed = append(*hashed, batch.Files...)
+			if !yield(batch) {
+				return
+			}
+		}
+	}
 }
 
-func displayDuplicates(groups []detector.DuplicateGroup) {
+func readFileList(name string) ([]scanner.FileInfo, error) {
+	var r io.Reader = os.Stdin
+	if name == "-" {
+		if !dryRun && !autoDelete {
+			fmt.Fprintln(os.Stderr, "Warning: stdin holds the file list, so interactive prompts will be skipped")
+		}
+	} else {
+		// #nosec G304 - the list file is named explicitly by the user
+		file, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	files, skipped, err := scanner.ReadFileList(r, nullSep, scanOpts)
+	for _, e := range skipped {
+		fmt.Fprintf(os.Stderr, "Skipping %v\n", e)
+	}
+	return files, err
+}
+
+func displayDuplicates(ctx context.Context, dirGroups []detector.DirectoryGroup, groups []detector.DuplicateGroup) {
 	if showAll {
-		displayAllThenDelete(groups)
+		displayAllThenDelete(ctx, dirGroups, groups)
 		return
 	}
 
-	for i, group := range groups {
-		similarityTag := ""
-		if group.IsImage {
-			similarityTag = fmt.Sprintf(" ~%d%% similar", group.Similarity)
+	for i, group := range dirGroups {
+		if ctx.Err() != nil {
+			return
 		}
-		fmt.Printf("\nGroup %d (%.2f MB, %d files%s):\n", i+1, float64(group.Size)/(1024*1024), len(group.Files), similarityTag)
+		fmt.Printf("\nDirectory group %d (%.2f MB, %d files each, %d copies):\n", i+1, float64(group.Size)/(1024*1024), group.FileCount, len(group.Dirs))
 
-		displayGroupTable(group.Files)
+		displayDirectoryTable(group)
 
 		if dryRun {
 			continue
 		}
 
 		if autoDelete {
-			deleteFiles(group.Files, 0)
+			deleteDirs(group.Dirs, 0)
 			continue
 		}
 
-		fmt.Print("\nKeep [1-" + fmt.Sprintf("%d", len(group.Files)) + "/all/skip]: ")
-		reader := bufio.NewReader(os.Stdin)
-		input, _ := reader.ReadString('\n')
-		input = strings.TrimSpace(input)
-
-		if input == "" || input == "skip" {
-			fmt.Println("Skipped")
+		keepIndex, ok := promptKeep(ctx, len(group.Dirs))
+		if !ok {
 			continue
 		}
 
-		if input == "all" {
-			fmt.Println("Kept all")
-			continue
+		deleteDirs(group.Dirs, keepIndex)
+	}
+
+	for i, group := range groups {
+		if ctx.Err() != nil {
+			return
 		}
+		handleGroup(ctx, i+1, group)
+	}
+}
 
-		var keepIndex int
-		if _, err := fmt.Sscanf(input, "%d", &keepIndex); err != nil || keepIndex < 1 || keepIndex > len(group.Files) {
-			fmt.Println("Invalid, skipped")
-			continue
+// handleGroup shows one duplicate group and applies the chosen action.
+func handleGroup(ctx context.Context, n int, group detector.DuplicateGroup) {
+	keep := group.KeepIndex(keepPolicy)
+	fmt.Printf("\nGroup %d (%.2f MB, %d files%s):\n", n, float64(group.Files[keep].Size)/(1024*1024), len(group.Files), groupTag(group))
+
+	displayGroupTable(group, keep)
+	displayTagDiff(group)
+
+	if dryRun {
+		return
+	}
+
+	if autoDelete {
+		deleteFiles(group.Files, keep)
+		return
+	}
+
+	keepIndex, ok := promptKeep(ctx, len(group.Files))
+	if !ok {
+		return
+	}
+
+	deleteFiles(group.Files, keepIndex)
+}
+
+func displaySymlinks(entries []scanner.FileInfo) {
+	var links []scanner.FileInfo
+	for _, entry := range entries {
+		if entry.Type() == os.ModeSymlink {
+			links = append(links, entry)
 		}
+	}
+	if len(links) == 0 {
+		return
+	}
+
+	fmt.Printf("Found %d symlinks (not followed):\n", len(links))
+	table := tablewriter.NewTable(os.Stdout)
+	table.Header("Link", "Target")
+	for _, link := range links {
+		_ = table.Append(link.Path, link.Link)
+	}
+	_ = table.Render()
+	fmt.Println()
+}
 
-		deleteFiles(group.Files, keepIndex-1)
+// promptKeep asks which of n entries to keep and returns its zero-based
+// index, or false when the group should be left alone or the prompt was
+// interrupted.
+func promptKeep(ctx context.Context, n int) (int, bool) {
+	fmt.Print("\nKeep [1-" + fmt.Sprintf("%d", n) + "/all/skip]: ")
+	input, _ := readLine(ctx)
+	input = strings.TrimSpace(input)
+
+	if input == "" || input == "skip" {
+		fmt.Println("Skipped")
+		return 0, false
 	}
+
+	if input == "all" {
+		fmt.Println("Kept all")
+		return 0, false
+	}
+
+	var keepIndex int
+	if _, err := fmt.Sscanf(input, "%d", &keepIndex); err != nil || keepIndex < 1 || keepIndex > n {
+		fmt.Println("Invalid, skipped")
+		return 0, false
+	}
+
+	return keepIndex - 1, true
 }
 
-func displayGroupTable(files []scanner.FileInfo) {
+func displayDirectoryTable(group detector.DirectoryGroup) {
 	table := tablewriter.NewTable(os.Stdout)
-	table.Header("#", "Filename", "Location", "Size (MB)")
+	table.Header("#", "Directory", "Files", "Size (MB)")
 
-	for i, file := range files {
-		filename := filepath.Base(file.Path)
-		location := filepath.Dir(file.Path)
-		sizeMB := fmt.Sprintf("%.2f", float64(file.Size)/(1024*1024))
+	sizeMB := fmt.Sprintf("%.2f", float64(group.Size)/(1024*1024))
+	for i, dir := range group.Dirs {
 		_ = table.Append(
 			fmt.Sprintf("[%d]", i+1),
-			filename,
-			location,
+			dir,
+			fmt.Sprintf("%d", group.FileCount),
 			sizeMB,
 		)
 	}
@@ -195,37 +552,156 @@ func displayGroupTable(files []scanner.FileInfo) {
 	_ = table.Render()
 }
 
-func displayAllThenDelete(groups []detector.DuplicateGroup) {
-	fmt.Println("=== All Duplicate Groups ===")
+func displayGroupTable(group detector.DuplicateGroup, keep int) {
+	table := tablewriter.NewTable(os.Stdout)
+	table.Header(groupHeader(group, "#")...)
 
-	for i, group := range groups {
-		similarityTag := ""
-		if group.IsImage {
-			similarityTag = fmt.Sprintf(" ~%d%% similar", group.Similarity)
+	for i := range group.Files {
+		_ = table.Append(groupRow(group, i, keep, fmt.Sprintf("[%d]", i+1))...)
+	}
+
+	_ = table.Render()
+}
+
+// groupTag tells how the files of a group matched, for its title line.
+func groupTag(group detector.DuplicateGroup) string {
+	switch {
+	case group.IsImage, group.IsAudio && group.Algorithm != "":
+		return fmt.Sprintf(" ~%d%% similar by %s", group.Similarity, group.Algorithm)
+	case group.IsAudio && len(group.TagDiff()) > 0:
+		return " same audio, different tags"
+	case group.IsAudio:
+		return " same audio"
+	case group.IsText:
+		return " equivalent text"
+	}
+	return ""
+}
+
+// displayTagDiff shows the tag fields that differ between the files of an
+// audio group, one row per file numbered as in the group table.
+func displayTagDiff(group detector.DuplicateGroup) {
+	names := group.TagDiff()
+	if len(names) == 0 {
+		return
+	}
+
+	header := []any{"#"}
+	for _, name := range names {
+		header = append(header, name)
+	}
+	table := tablewriter.NewTable(os.Stdout)
+	table.Header(header...)
+	for i, tags := range group.Tags {
+		row := []any{fmt.Sprintf("[%d]", i+1)}
+		for _, name := range names {
+			row = append(row, tags[name])
 		}
-		fmt.Printf("Group %d (%.2f MB, %d files%s):\n", i+1, float64(group.Size)/(1024*1024), len(group.Files), similarityTag)
+		_ = table.Append(row...)
+	}
+	_ = table.Render()
+}
+
+// groupHeader and groupRow add the detected type of each file to the file
+// columns, a description of each image in image groups, and for image groups
+// matched with --invariant an Orientation column telling how each file
+// differs from the kept one.
+func groupHeader(group detector.DuplicateGroup, first string) []any {
+	header := fileHeader(first)
+	if group.Types != nil {
+		header = append(header, "Type")
+	}
+	if group.Images != nil {
+		header = append(header, "Image")
+	}
+	if group.Transforms != nil {
+		header = append(header, "Orientation")
+	}
+	return header
+}
+
+func groupRow(group detector.DuplicateGroup, i, keep int, first string) []any {
+	row := fileRow(first, group.Files[i])
+	if group.Types != nil {
+		row = append(row, group.Types[i])
+	}
+	if group.Images != nil {
+		row = append(row, group.Images[i].String())
+	}
+	if group.Transforms != nil {
+		row = append(row, group.Orientation(i, keep).String())
+	}
+	return row
+}
+
+// fileHeader and fileRow build the columns shared by every file table. The
+// Root column only appears when more than one directory was scanned.
+func fileHeader(first string) []any {
+	header := []any{first, "Filename", "Location", "Size (MB)", "Modified"}
+	if showRoots {
+		header = append(header, "Root")
+	}
+	return header
+}
+
+func fileRow(first string, file scanner.FileInfo) []any {
+	filename := filepath.Base(file.Path)
+	location := filepath.Dir(file.Path)
+	sizeMB := fmt.Sprintf("%.2f", float64(file.Size)/(1024*1024))
+
+	modified := file.ModTime.Format("2006-01-02 15:04")
+
+	row := []any{first, filename, location, sizeMB, modified}
+	if showRoots {
+		row = append(row, file.Root)
+	}
+	return row
+}
+
+func displayAllThenDelete(ctx context.Context, dirGroups []detector.DirectoryGroup, groups []detector.DuplicateGroup) {
+	fmt.Println("=== All Duplicate Groups ===")
+
+	for i, group := range dirGroups {
+		fmt.Printf("Directory group %d (%.2f MB, %d files each):\n", i+1, float64(group.Size)/(1024*1024), group.FileCount)
 
 		table := tablewriter.NewTable(os.Stdout)
-		table.Header("Action", "Filename", "Location", "Size (MB)")
+		table.Header("Action", "Directory", "Files", "Size (MB)")
 
-		for j, file := range group.Files {
+		sizeMB := fmt.Sprintf("%.2f", float64(group.Size)/(1024*1024))
+		for j, dir := range group.Dirs {
 			action := "[DEL]"
 			if j == 0 {
 				action = "[KEEP]"
 			}
-			filename := filepath.Base(file.Path)
-			location := filepath.Dir(file.Path)
-			sizeMB := fmt.Sprintf("%.2f", float64(file.Size)/(1024*1024))
-			_ = table.Append(action, filename, location, sizeMB)
+			_ = table.Append(action, dir, fmt.Sprintf("%d", group.FileCount), sizeMB)
 		}
 
 		_ = table.Render()
 		fmt.Println()
 	}
 
-	fmt.Print("\nDelete all duplicates (keep first file in each group)? [y/N]: ")
-	reader := bufio.NewReader(os.Stdin)
-	input, _ := reader.ReadString('\n')
+	for i, group := range groups {
+		keep := group.KeepIndex(keepPolicy)
+		fmt.Printf("Group %d (%.2f MB, %d files%s):\n", i+1, float64(group.Files[keep].Size)/(1024*1024), len(group.Files), groupTag(group))
+
+		table := tablewriter.NewTable(os.Stdout)
+		table.Header(groupHeader(group, "Action")...)
+
+		for j := range group.Files {
+			action := "[DEL]"
+			if j == keep {
+				action = "[KEEP]"
+			}
+			_ = table.Append(groupRow(group, j, keep, action)...)
+		}
+
+		_ = table.Render()
+		displayTagDiff(group)
+		fmt.Println()
+	}
+
+	fmt.Print("\nDelete all duplicates (keep the [KEEP] entry in each group)? [y/N]: ")
+	input, _ := readLine(ctx)
 	input = strings.ToLower(strings.TrimSpace(input))
 
 	if input != "y" && input != "yes" {
@@ -236,29 +712,58 @@ func displayAllThenDelete(groups []detector.DuplicateGroup) {
 	fmt.Println("\nDeleting duplicates...")
 	var totalDeleted, totalErrors int
 
+	for i, group := range dirGroups {
+		if ctx.Err() != nil {
+			break
+		}
+		fmt.Printf("\nDirectory group %d:\n", i+1)
+		deleted, errors := deleteDirs(group.Dirs, 0)
+		totalDeleted += deleted
+		totalErrors += errors
+	}
+
 	for i, group := range groups {
+		if ctx.Err() != nil {
+			break
+		}
 		fmt.Printf("\nGroup %d:\n", i+1)
-		deleted, errors := deleteFilesCount(group.Files, 0)
+		deleted, errors := deleteFilesCount(group.Files, group.KeepIndex(keepPolicy))
 		totalDeleted += deleted
 		totalErrors += errors
 	}
 
-	fmt.Printf("\n✓ Deleted %d files", totalDeleted)
+	fmt.Printf("\n✓ Deleted %d files and directories", totalDeleted)
 	if totalErrors > 0 {
 		fmt.Printf(" (%d errors)", totalErrors)
 	}
 	fmt.Println()
+	if ctx.Err() != nil {
+		fmt.Println("Interrupted: the remaining groups were left untouched")
+	}
 }
 
 func deleteFilesCount(files []scanner.FileInfo, keepIndex int) (int, int) {
 	deleted, errors := 0, 0
+	kept := files[keepIndex].Path
+	if !keptExists(kept) {
+		return 0, 1
+	}
+
 	for i, file := range files {
 		if i == keepIndex {
 			fmt.Printf("  ✓ %s\n", file.Path)
 			continue
 		}
+		if sameFile(file.Path, kept) {
+			fmt.Printf("  ✓ %s is the kept file, left alone\n", file.Path)
+			continue
+		}
+		// The kept copy may have gone since the last file was removed.
+		if !keptExists(kept) {
+			return deleted, errors + 1
+		}
 
-		if err := os.Remove(file.Path); err != nil {
+		if err := uninterruptible(func() error { return os.Remove(file.Path) }); err != nil {
 			fmt.Printf("  ✗ %s: %v\n", file.Path, err)
 			errors++
 		} else {
@@ -270,16 +775,47 @@ func deleteFilesCount(files []scanner.FileInfo, keepIndex int) (int, int) {
 }
 
 func deleteFiles(files []scanner.FileInfo, keepIndex int) {
-	for i, file := range files {
+	deleteFilesCount(files, keepIndex)
+}
+
+// sameFile reports whether both paths lead to the same file, through a
+// symlink or a hard link, so removing one could take the other with it.
+func sameFile(path1, path2 string) bool {
+	info1, err1 := os.Stat(path1)
+	info2, err2 := os.Stat(path2)
+	return err1 == nil && err2 == nil && os.SameFile(info1, info2)
+}
+
+// deleteDirs removes every directory tree except the one at keepIndex.
+func deleteDirs(dirs []string, keepIndex int) (int, int) {
+	deleted, errors := 0, 0
+	if !keptExists(dirs[keepIndex]) {
+		return 0, 1
+	}
+
+	for i, dir := range dirs {
 		if i == keepIndex {
-			fmt.Printf("  ✓ %s\n", file.Path)
+			fmt.Printf("  ✓ %s\n", dir)
 			continue
 		}
 
-		if err := os.Remove(file.Path); err != nil {
-			fmt.Printf("  ✗ %s: %v\n", file.Path, err)
+		if err := uninterruptible(func() error { return os.RemoveAll(dir) }); err != nil {
+			fmt.Printf("  ✗ %s: %v\n", dir, err)
+			errors++
 		} else {
-			fmt.Printf("  ✓ Deleted %s\n", file.Path)
+			fmt.Printf("  ✓ Deleted %s\n", dir)
+			deleted++
 		}
 	}
+	return deleted, errors
+}
+
+// keptExists guards against removing the last copy when the entry to keep
+// was already deleted, e.g. as part of a duplicate directory.
+func keptExists(path string) bool {
+	if _, err := os.Lstat(path); err != nil {
+		fmt.Printf("  ✗ %s: kept copy is missing, skipped\n", path)
+		return false
+	}
+	return true
 }
@@ -0,0 +1,412 @@
+package checkpoint
+
+import (
+	"doppel/internal/hasher"
+	"doppel/internal/scanner"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// formatVersion changes whenever old checkpoint files can no longer be read,
+// or their hashes would no longer match newly computed ones.
+const formatVersion = 3
+
+// entry is a hash recorded together with the metadata that identifies the
+// version of the file it was computed from. The device number is left out
+// because it can change across reboots and remounts.
+type entry struct {
+	Size       int64     `json:"size"`
+	ModTime    time.Time `json:"mtime"`
+	ChangeTime time.Time `json:"ctime,omitzero"`
+	Ino        uint64    `json:"ino,omitempty"`
+	Hash       string    `json:"hash,omitempty"`
+	MIME       string    `json:"mime,omitempty"`
+	PHash      []string  `json:"phash,omitempty"`
+	// Orientations holds the perceptual hashes of each orientation, for
+	// runs with --invariant.
+	Orientations [][]string  `json:"orientations,omitempty"`
+	Image        *imageEntry `json:"image,omitempty"`
+	// Audio, Fingerprint and Tags are recorded for runs with --audio or
+	// --fingerprint.
+	Audio       string            `json:"audio,omitempty"`
+	Fingerprint string            `json:"fingerprint,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	// Text is recorded for runs with --text-normalize.
+	Text string `json:"text,omitempty"`
+}
+
+type imageEntry struct {
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Format   string `json:"format"`
+	BitDepth int    `json:"bits,omitempty"`
+	Quality  int    `json:"quality,omitempty"`
+}
+
+type file struct {
+	Version int              `json:"version"`
+	Saved   time.Time        `json:"saved"`
+	Roots   []string         `json:"roots,omitempty"`
+	Files   map[string]entry `json:"files"`
+}
+
+// Checkpoint records completed hashes so an interrupted run can be resumed.
+// It implements hasher.Cache: a hash is only reused when the file's size,
+// modification and change times and inode number still match, so files changed
+// since the checkpoint are hashed again. Files are recorded by absolute path,
+// so a run with relative directories can be resumed from anywhere. Entries
+// for files this run has not reached yet are kept, so a resumed run that is
+// interrupted again loses nothing; once a scan completes, entries for files
+// it did not find are dropped.
+type Checkpoint struct {
+	path  string
+	roots []string
+	// dir is the working directory relative paths are resolved against.
+	dir string
+
+	mu      sync.Mutex
+	entries map[string]entry
+	seen    map[string]bool
+	reused  map[string]bool
+	dirty   bool
+}
+
+// New starts an empty checkpoint that will be written to path.
+func New(path string) *Checkpoint {
+	dir, _ := os.Getwd()
+	return &Checkpoint{
+		path:    path,
+		dir:     dir,
+		entries: make(map[string]entry),
+		seen:    make(map[string]bool),
+		reused:  make(map[string]bool),
+	}
+}
+
+// Load reads the checkpoint at path. Saving writes back to the same file.
+func Load(path string) (*Checkpoint, error) {
+	// #nosec G304 - the checkpoint file is named explicitly by the user
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("reading checkpoint %s: %w", path, err)
+	}
+	if f.Version != formatVersion {
+		return nil, fmt.Errorf("checkpoint %s has unsupported version %d", path, f.Version)
+	}
+
+	c := New(path)
+	c.roots = f.Roots
+	if f.Files != nil {
+		c.entries = f.Files
+	}
+	return c, nil
+}
+
+// Roots returns the directories of the run that wrote the checkpoint.
+func (c *Checkpoint) Roots() []string {
+	return c.roots
+}
+
+// SetRoots records the directories being scanned, as absolute paths so the
+// run can be resumed from another working directory. It does nothing on a
+// nil Checkpoint.
+func (c *Checkpoint) SetRoots(roots []string) {
+	if c == nil {
+		return
+	}
+
+	abs := make([]string, len(roots))
+	for i, root := range roots {
+		abs[i] = root
+		if path, err := filepath.Abs(root); err == nil {
+			abs[i] = path
+		}
+	}
+
+	c.mu.Lock()
+	c.roots = abs
+	c.dirty = true
+	c.mu.Unlock()
+}
+
+func (c *Checkpoint) Path() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.path
+}
+
+// SetPath changes where the checkpoint is saved.
+func (c *Checkpoint) SetPath(path string) {
+	c.mu.Lock()
+	c.path = path
+	c.dirty = true
+	c.mu.Unlock()
+}
+
+// Len returns the number of files with recorded hashes.
+func (c *Checkpoint) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Reused returns how many files had lookups answered from the checkpoint.
+func (c *Checkpoint) Reused() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.reused)
+}
+
+// key returns the absolute path a file is recorded under.
+func (c *Checkpoint) key(path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(c.dir, path)
+}
+
+// Seen notes that the current scan found f, so its entry survives Prune. It
+// does nothing on a nil Checkpoint.
+func (c *Checkpoint) Seen(f scanner.FileInfo) {
+	if c == nil {
+		return
+	}
+	key := c.key(f.Path)
+	c.mu.Lock()
+	c.seen[key] = true
+	c.mu.Unlock()
+}
+
+// Prune drops the entries of files the current scan did not find, which were
+// deleted or lie outside the scanned directories. It must only be called
+// once the scan is complete, and does nothing on a nil Checkpoint.
+func (c *Checkpoint) Prune() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		if !c.seen[key] {
+			delete(c.entries, key)
+			c.dirty = true
+		}
+	}
+}
+
+func (c *Checkpoint) Lookup(f scanner.FileInfo, opts hasher.Options) (hasher.HashedFile, bool) {
+	key := c.key(f.Path)
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+	if !ok || !e.matches(f) {
+		return hasher.HashedFile{}, false
+	}
+
+	h := hasher.HashedFile{FileInfo: f, Hash: e.Hash, MIME: e.MIME, Audio: e.Audio, Tags: e.Tags, Text: e.Text}
+	// Perceptual hashes recorded without the image's description predate
+	// it, and are computed again so reports can show it.
+	if phash, err := parseHashes(e.PHash); err == nil && e.Image != nil {
+		h.PHash = phash
+		h.Image = hasher.ImageInfo{
+			Width:    e.Image.Width,
+			Height:   e.Image.Height,
+			Format:   e.Image.Format,
+			BitDepth: e.Image.BitDepth,
+			Quality:  e.Image.Quality,
+		}
+	}
+	if e.Fingerprint != "" {
+		if fingerprint, err := hasher.ParseFingerprint(e.Fingerprint); err == nil {
+			h.Fingerprint = fingerprint
+		}
+	}
+	for _, values := range e.Orientations {
+		hashes, err := parseHashes(values)
+		if err != nil {
+			h.Orientations = nil
+			break
+		}
+		h.Orientations = append(h.Orientations, hashes)
+	}
+
+	h = opts.Compatible(h)
+	if h.Hash == "" && h.PHash == nil && !h.IsAudio && !h.IsText {
+		return hasher.HashedFile{}, false
+	}
+
+	c.mu.Lock()
+	c.reused[key] = true
+	c.mu.Unlock()
+	return h, true
+}
+
+func (c *Checkpoint) Store(h hasher.HashedFile) {
+	key := c.key(h.FileInfo.Path)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || !e.matches(h.FileInfo) {
+		e = newEntry(h.FileInfo)
+	}
+	if h.Hash != "" {
+		e.Hash = h.Hash
+	}
+	if h.MIME != "" {
+		e.MIME = h.MIME
+	}
+	if h.PHash != nil {
+		e.PHash = formatHashes(h.PHash)
+		e.Image = &imageEntry{
+			Width:    h.Image.Width,
+			Height:   h.Image.Height,
+			Format:   h.Image.Format,
+			BitDepth: h.Image.BitDepth,
+			Quality:  h.Image.Quality,
+		}
+		e.Orientations = nil
+		for _, hashes := range h.Orientations {
+			e.Orientations = append(e.Orientations, formatHashes(hashes))
+		}
+	}
+	if h.Audio != "" {
+		e.Audio = h.Audio
+	}
+	if h.Fingerprint != nil {
+		e.Fingerprint = h.Fingerprint.String()
+	}
+	if h.IsAudio {
+		e.Tags = h.Tags
+	}
+	if h.Text != "" {
+		e.Text = h.Text
+	}
+	c.entries[key] = e
+	c.dirty = true
+}
+
+func formatHashes(hashes []hasher.ImageHash) []string {
+	result := make([]string, len(hashes))
+	for i, h := range hashes {
+		result[i] = h.String()
+	}
+	return result
+}
+
+func parseHashes(values []string) ([]hasher.ImageHash, error) {
+	var result []hasher.ImageHash
+	for _, s := range values {
+		h, err := hasher.ParseImageHash(s)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, h)
+	}
+	return result, nil
+}
+
+func newEntry(f scanner.FileInfo) entry {
+	return entry{
+		Size:       f.Size,
+		ModTime:    f.ModTime,
+		ChangeTime: f.ChangeTime,
+		Ino:        f.Ino,
+	}
+}
+
+func (e entry) matches(f scanner.FileInfo) bool {
+	return e.Size == f.Size &&
+		e.ModTime.Equal(f.ModTime) &&
+		e.ChangeTime.Equal(f.ChangeTime) &&
+		e.Ino == f.Ino
+}
+
+// Save writes the checkpoint if anything changed since the last save. The
+// file is replaced atomically, so a crash while saving keeps the previous
+// checkpoint intact. Only copying the entries holds up lookups and stores;
+// encoding them does not.
+func (c *Checkpoint) Save() error {
+	c.mu.Lock()
+	if !c.dirty {
+		c.mu.Unlock()
+		return nil
+	}
+	f := file{
+		Version: formatVersion,
+		Saved:   time.Now(),
+		Roots:   c.roots,
+		Files:   maps.Clone(c.entries),
+	}
+	path := c.path
+	c.dirty = false
+	c.mu.Unlock()
+
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		c.mu.Lock()
+		c.dirty = true
+		c.mu.Unlock()
+	}
+	return err
+}
+
+// Autosave saves the checkpoint every interval until stop is called. Errors
+// are passed to report and do not stop later attempts.
+func (c *Checkpoint) Autosave(interval time.Duration, report func(error)) (stop func()) {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := c.Save(); err != nil {
+					report(err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-finished
+		})
+	}
+}
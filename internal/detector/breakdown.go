@@ -0,0 +1,117 @@
+package detector
+
+import (
+	"cmp"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Share is the part of the wasted space that falls under one name.
+type Share struct {
+	Name  string
+	Files int
+	Bytes int64
+}
+
+// Breakdown splits wasted space by kind of group, by file extension and by
+// top-level directory. Each list is sorted by size, largest first.
+type Breakdown struct {
+	Kinds       []Share
+	Extensions  []Share
+	Directories []Share
+}
+
+// Kind names the kind of match that made a group.
+func (g DuplicateGroup) Kind() string {
+	switch {
+	case g.IsImage:
+		return "similar images"
+	case g.IsAudio && g.Algorithm != "":
+		return "similar audio"
+	case g.IsAudio:
+		return "same audio"
+	case g.IsText:
+		return "equivalent text"
+	}
+	return "identical files"
+}
+
+// BreakDownWastedSpace attributes each file counted by CalculateWastedSpace
+// to its group's kind, its extension and its top-level directory.
+func BreakDownWastedSpace(groups []DuplicateGroup, policy KeepPolicy) Breakdown {
+	kinds := make(map[string]*Share)
+	extensions := make(map[string]*Share)
+	directories := make(map[string]*Share)
+
+	for _, group := range groups {
+		for _, file := range group.Wasted(policy) {
+			ext := strings.ToLower(filepath.Ext(file.Path))
+			if ext == "" {
+				ext = "(none)"
+			}
+			addShare(kinds, group.Kind(), file.Size)
+			addShare(extensions, ext, file.Size)
+			addShare(directories, topDirectory(file.Path, file.Root), file.Size)
+		}
+	}
+
+	return Breakdown{
+		Kinds:       sortedShares(kinds),
+		Extensions:  sortedShares(extensions),
+		Directories: sortedShares(directories),
+	}
+}
+
+func addShare(shares map[string]*Share, name string, size int64) {
+	s, ok := shares[name]
+	if !ok {
+		s = &Share{Name: name}
+		shares[name] = s
+	}
+	s.Files++
+	s.Bytes += size
+}
+
+func sortedShares(shares map[string]*Share) []Share {
+	result := make([]Share, 0, len(shares))
+	for _, s := range shares {
+		result = append(result, *s)
+	}
+	slices.SortFunc(result, func(a, b Share) int {
+		if c := cmp.Compare(b.Bytes, a.Bytes); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return result
+}
+
+// topDirectory returns the directory directly below the scanned root that
+// holds path, or the root itself for files directly in it. Files from a
+// list have no root, and are attributed to the first component of their
+// path.
+func topDirectory(path, root string) string {
+	if root != "" {
+		rel, err := filepath.Rel(root, path)
+		if err == nil && !strings.HasPrefix(rel, "..") {
+			first, _, found := strings.Cut(filepath.ToSlash(rel), "/")
+			if !found {
+				return root
+			}
+			return filepath.Join(root, first)
+		}
+	}
+
+	volume := filepath.VolumeName(path)
+	rest := filepath.ToSlash(path[len(volume):])
+	prefix := ""
+	if strings.HasPrefix(rest, "/") {
+		prefix, rest = string(filepath.Separator), rest[1:]
+	}
+	first, _, found := strings.Cut(rest, "/")
+	if !found {
+		return "."
+	}
+	return volume + prefix + first
+}
@@ -1,8 +1,12 @@
 package detector
 
 import (
+	"context"
 	"doppel/internal/hasher"
 	"doppel/internal/scanner"
+	"iter"
+	"slices"
+	"strings"
 )
 
 type DuplicateGroup struct {
@@ -11,26 +15,127 @@ type DuplicateGroup struct {
 	Size       int64
 	Similarity int
 	IsImage    bool
+	// IsAudio marks a group of files with the same audio, whose tags may
+	// differ.
+	IsAudio bool
+	// IsText marks a group of text files that are only the same once
+	// normalized.
+	IsText bool
+	// Algorithm names the perceptual hashes an image group was matched
+	// with, e.g. "difference" or "difference+perception", or the acoustic
+	// fingerprint of a group of similar audio.
+	Algorithm string
+	// Types holds the detected MIME type of each of Files, or "" where it
+	// is unknown.
+	Types []string
+	// Images describes each of Files in an image group.
+	Images []hasher.ImageInfo
+	// Transforms tells, for each of Files, how it relates to the first
+	// file. It is only set for image groups matched in every orientation;
+	// see Orientation.
+	Transforms []hasher.Transform
+	// Tags holds the tags of each of Files in an audio group.
+	Tags []hasher.Tags
 }
 
-func FindDuplicates(hashed []hasher.HashedFile, threshold int) []DuplicateGroup {
-	var images, nonImages []hasher.HashedFile
+// FindDuplicates groups a complete list of hashed files. threshold applies
+// to images and audioThreshold to acoustic fingerprints.
+func FindDuplicates(ctx context.Context, hashed []hasher.HashedFile, threshold, audioThreshold int) []DuplicateGroup {
+	var images, fingerprinted, audio, texts, nonImages []hasher.HashedFile
 	for _, h := range hashed {
-		if h.IsImage {
+		switch {
+		case h.IsImage:
 			images = append(images, h)
-		} else {
+		case h.IsText:
+			texts = append(texts, h)
+		case h.Fingerprint != nil:
+			fingerprinted = append(fingerprinted, h)
+		case h.IsAudio:
+			audio = append(audio, h)
+		default:
 			nonImages = append(nonImages, h)
 		}
 	}
 
 	var duplicates []DuplicateGroup
-	duplicates = append(duplicates, findImageDuplicates(images, threshold)...)
+	duplicates = append(duplicates, findImageDuplicates(ctx, images, threshold)...)
+	duplicates = append(duplicates, findFingerprintDuplicates(ctx, fingerprinted, audioThreshold)...)
+	duplicates = append(duplicates, findAudioDuplicates(audio)...)
+	duplicates = append(duplicates, findTextDuplicates(texts)...)
 	duplicates = append(duplicates, findExactDuplicates(nonImages)...)
 
 	return duplicates
 }
 
-func findImageDuplicates(images []hasher.HashedFile, threshold int) []DuplicateGroup {
+// StreamDuplicates finds duplicates batch by batch. Exact duplicate groups
+// are yielded as soon as their batch arrives; similar images, audio and
+// normalized text can only be grouped once every batch has been seen, so
+// their groups come last. When ctx is cancelled the batches are incomplete, and those groups
+// are left out rather than reported with members missing.
+func StreamDuplicates(ctx context.Context, batches iter.Seq[hasher.Batch], threshold, audioThreshold int) iter.Seq[DuplicateGroup] {
+	return func(yield func(DuplicateGroup) bool) {
+		var images, fingerprinted, audio, texts []hasher.HashedFile
+		for batch := range batches {
+			var nonImages []hasher.HashedFile
+			for _, h := range batch.Files {
+				switch {
+				case h.IsImage:
+					images = append(images, h)
+				case h.IsText:
+					texts = append(texts, h)
+				case h.Fingerprint != nil:
+					fingerprinted = append(fingerprinted, h)
+				case h.IsAudio:
+					audio = append(audio, h)
+				default:
+					nonImages = append(nonImages, h)
+				}
+			}
+
+			for _, group := range findExactDuplicates(nonImages) {
+				if !yield(group) {
+					return
+				}
+			}
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		hasher.SortByPath(images)
+		for _, group := range findImageDuplicates(ctx, images, threshold) {
+			if !yield(group) {
+				return
+			}
+		}
+
+		hasher.SortByPath(fingerprinted)
+		for _, group := range findFingerprintDuplicates(ctx, fingerprinted, audioThreshold) {
+			if !yield(group) {
+				return
+			}
+		}
+
+		hasher.SortByPath(audio)
+		for _, group := range findAudioDuplicates(audio) {
+			if !yield(group) {
+				return
+			}
+		}
+
+		hasher.SortByPath(texts)
+		for _, group := range findTextDuplicates(texts) {
+			if !yield(group) {
+				return
+			}
+		}
+	}
+}
+
+// findImageDuplicates groups images around a seed. threshold counts
+// differing bits of a 64-bit hash; see hasher.ImageDistance for longer ones.
+func findImageDuplicates(ctx context.Context, images []hasher.HashedFile, threshold int) []DuplicateGroup {
 	var duplicates []DuplicateGroup
 	used := make(map[int]bool)
 
@@ -38,11 +143,17 @@ func findImageDuplicates(images []hasher.HashedFile, threshold int) []DuplicateG
 		if used[i] {
 			continue
 		}
+		if ctx.Err() != nil {
+			return nil
+		}
 
 		var group []scanner.FileInfo
 		group = append(group, images[i].FileInfo)
+		types := []string{images[i].MIME}
+		infos := []hasher.ImageInfo{images[i].Image}
+		transforms := []hasher.Transform{hasher.Identity}
 
-		var totalDistance int
+		var totalDistance float64
 		var comparisons int
 
 		for j := i + 1; j < len(images); j++ {
@@ -50,28 +161,38 @@ func findImageDuplicates(images []hasher.HashedFile, threshold int) []DuplicateG
 				continue
 			}
 
-			distance, _ := images[i].PHash.Distance(images[j].PHash)
-			if distance <= threshold {
+			distance, transform, ok := hasher.MatchImages(images[i], images[j])
+			if ok && distance <= float64(threshold) {
 				group = append(group, images[j].FileInfo)
+				types = append(types, images[j].MIME)
+				infos = append(infos, images[j].Image)
+				transforms = append(transforms, transform)
 				used[j] = true
 				totalDistance += distance
 				comparisons++
 			}
 		}
 
-		if len(group) > 1 {
-			avgDistance := 0
+		if distinctFiles(group) > 1 {
+			avgDistance := 0.0
 			if comparisons > 0 {
-				avgDistance = totalDistance / comparisons
+				avgDistance = totalDistance / float64(comparisons)
+			}
+			similarity := 100 - int(avgDistance*100/64)
+			if images[i].Orientations == nil {
+				transforms = nil
 			}
-			similarity := 100 - (avgDistance * 100 / 64)
 
 			duplicates = append(duplicates, DuplicateGroup{
-				Hash:       images[i].PHash.ToString(),
+				Hash:       images[i].PHash[0].String(),
 				Files:      group,
 				Size:       images[i].FileInfo.Size,
 				Similarity: similarity,
 				IsImage:    true,
+				Algorithm:  algorithmNames(images[i].PHash),
+				Types:      types,
+				Images:     infos,
+				Transforms: transforms,
 			})
 		}
 		used[i] = true
@@ -80,33 +201,298 @@ func findImageDuplicates(images []hasher.HashedFile, threshold int) []DuplicateG
 	return duplicates
 }
 
+func algorithmNames(hashes []hasher.ImageHash) string {
+	names := make([]string, len(hashes))
+	for i, h := range hashes {
+		names[i] = string(h.Algorithm)
+	}
+	return strings.Join(names, "+")
+}
+
+// findFingerprintDuplicates groups audio around a seed, like
+// findImageDuplicates. threshold is the percentage of fingerprint bits that
+// may differ; see hasher.FingerprintDistance.
+func findFingerprintDuplicates(ctx context.Context, tracks []hasher.HashedFile, threshold int) []DuplicateGroup {
+	var duplicates []DuplicateGroup
+	used := make(map[int]bool)
+
+	for i := 0; i < len(tracks); i++ {
+		if used[i] {
+			continue
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		group := []scanner.FileInfo{tracks[i].FileInfo}
+		types := []string{tracks[i].MIME}
+		tags := []hasher.Tags{tracks[i].Tags}
+
+		var totalDistance float64
+		var comparisons int
+
+		for j := i + 1; j < len(tracks); j++ {
+			if used[j] {
+				continue
+			}
+
+			distance, ok := hasher.FingerprintDistance(tracks[i].Fingerprint, tracks[j].Fingerprint)
+			if ok && distance <= float64(threshold) {
+				group = append(group, tracks[j].FileInfo)
+				types = append(types, tracks[j].MIME)
+				tags = append(tags, tracks[j].Tags)
+				used[j] = true
+				totalDistance += distance
+				comparisons++
+			}
+		}
+
+		if distinctFiles(group) > 1 {
+			avgDistance := 0.0
+			if comparisons > 0 {
+				avgDistance = totalDistance / float64(comparisons)
+			}
+
+			duplicates = append(duplicates, DuplicateGroup{
+				Hash:       tracks[i].Fingerprint.String(),
+				Files:      group,
+				Size:       tracks[i].FileInfo.Size,
+				Similarity: 100 - int(avgDistance),
+				IsAudio:    true,
+				Algorithm:  hasher.FingerprintAlgorithm,
+				Types:      types,
+				Tags:       tags,
+			})
+		}
+		used[i] = true
+	}
+
+	return duplicates
+}
+
+// findAudioDuplicates groups files by the hash of their audio, in the order
+// their first files appear.
+func findAudioDuplicates(audio []hasher.HashedFile) []DuplicateGroup {
+	var order []string
+	hashGroups := make(map[string][]hasher.HashedFile)
+	for _, h := range audio {
+		if _, ok := hashGroups[h.Audio]; !ok {
+			order = append(order, h.Audio)
+		}
+		hashGroups[h.Audio] = append(hashGroups[h.Audio], h)
+	}
+
+	var duplicates []DuplicateGroup
+	for _, hash := range order {
+		hashed := hashGroups[hash]
+		files := make([]scanner.FileInfo, len(hashed))
+		types := make([]string, len(hashed))
+		tags := make([]hasher.Tags, len(hashed))
+		for i, h := range hashed {
+			files[i], types[i], tags[i] = h.FileInfo, h.MIME, h.Tags
+		}
+		if distinctFiles(files) > 1 {
+			duplicates = append(duplicates, DuplicateGroup{
+				Hash:       hash,
+				Files:      files,
+				Size:       files[0].Size,
+				Similarity: 100,
+				IsAudio:    true,
+				Types:      types,
+				Tags:       tags,
+			})
+		}
+	}
+
+	return duplicates
+}
+
+// findTextDuplicates groups text files by the hash of their canonical
+// form, in the order their first files appear. A group whose files are all
+// byte for byte the same is reported as an exact group.
+func findTextDuplicates(texts []hasher.HashedFile) []DuplicateGroup {
+	var order []string
+	hashGroups := make(map[string][]hasher.HashedFile)
+	for _, h := range texts {
+		if _, ok := hashGroups[h.Text]; !ok {
+			order = append(order, h.Text)
+		}
+		hashGroups[h.Text] = append(hashGroups[h.Text], h)
+	}
+
+	var duplicates []DuplicateGroup
+	for _, hash := range order {
+		hashed := hashGroups[hash]
+		files := make([]scanner.FileInfo, len(hashed))
+		types := make([]string, len(hashed))
+		identical := true
+		for i, h := range hashed {
+			files[i], types[i] = h.FileInfo, h.MIME
+			identical = identical && h.Hash != "" && h.Hash == hashed[0].Hash
+		}
+		if distinctFiles(files) < 2 {
+			continue
+		}
+
+		group := DuplicateGroup{
+			Hash:       hash,
+			Files:      files,
+			Size:       files[0].Size,
+			Similarity: 100,
+			IsText:     !identical,
+			Types:      types,
+		}
+		if identical {
+			group.Hash = hashed[0].Hash
+		}
+		duplicates = append(duplicates, group)
+	}
+
+	return duplicates
+}
+
+// TagDiff returns the sorted names of the tag fields whose values differ
+// between the files of an audio group.
+func (g DuplicateGroup) TagDiff() []string {
+	var names []string
+	for _, tags := range g.Tags {
+		for name := range tags {
+			if slices.Contains(names, name) {
+				continue
+			}
+			for _, other := range g.Tags {
+				if other[name] != tags[name] {
+					names = append(names, name)
+					break
+				}
+			}
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// findExactDuplicates groups files by their content hash, ordered by the
+// path of each group's first file so the report does not change between
+// runs.
 func findExactDuplicates(nonImages []hasher.HashedFile) []DuplicateGroup {
-	hashGroups := make(map[string][]scanner.FileInfo)
+	hashGroups := make(map[string][]hasher.HashedFile)
 
 	for _, h := range nonImages {
-		hashGroups[h.Hash] = append(hashGroups[h.Hash], h.FileInfo)
+		hashGroups[h.Hash] = append(hashGroups[h.Hash], h)
 	}
 
 	var duplicates []DuplicateGroup
-	for hash, files := range hashGroups {
-		if len(files) > 1 {
+	for hash, hashed := range hashGroups {
+		files := make([]scanner.FileInfo, len(hashed))
+		types := make([]string, len(hashed))
+		for i, h := range hashed {
+			files[i], types[i] = h.FileInfo, h.MIME
+		}
+		if distinctFiles(files) > 1 {
 			duplicates = append(duplicates, DuplicateGroup{
 				Hash:       hash,
 				Files:      files,
 				Size:       files[0].Size,
 				Similarity: 100,
 				IsImage:    false,
+				Types:      types,
 			})
 		}
 	}
 
+	SortGroups(duplicates)
 	return duplicates
 }
 
-func CalculateWastedSpace(groups []DuplicateGroup) int64 {
+// SortGroups orders groups by the path of their first file, in walk order.
+func SortGroups(groups []DuplicateGroup) {
+	slices.SortStableFunc(groups, func(a, b DuplicateGroup) int {
+		return scanner.ComparePaths(a.Files[0].Path, b.Files[0].Path)
+	})
+}
+
+// KeepPolicy chooses which file of a group is kept when the others are
+// deleted.
+type KeepPolicy string
+
+const (
+	// KeepFirst keeps the first file in path order.
+	KeepFirst KeepPolicy = "first"
+	// KeepHighestResolution keeps the image with the most pixels, and of
+	// those the largest file. Other groups keep their first file.
+	KeepHighestResolution KeepPolicy = "highest-resolution"
+)
+
+var KeepPolicies = []KeepPolicy{KeepFirst, KeepHighestResolution}
+
+// KeepIndex returns the index in Files of the file policy keeps.
+func (g DuplicateGroup) KeepIndex(policy KeepPolicy) int {
+	if policy != KeepHighestResolution || g.Images == nil {
+		return 0
+	}
+
+	best := 0
+	for i, info := range g.Images {
+		pixels, bestPixels := info.Pixels(), g.Images[best].Pixels()
+		if pixels > bestPixels || pixels == bestPixels && g.Files[i].Size > g.Files[best].Size {
+			best = i
+		}
+	}
+	return best
+}
+
+// Orientation tells how Files[i] relates to Files[keep], such as
+// "rotated 90° clockwise". The group must have Transforms.
+func (g DuplicateGroup) Orientation(i, keep int) hasher.Transform {
+	return g.Transforms[keep].Inverse().Then(g.Transforms[i])
+}
+
+// CalculateWastedSpace adds up the sizes of the files that deleting all but
+// the one policy keeps would free.
+func CalculateWastedSpace(groups []DuplicateGroup, policy KeepPolicy) int64 {
 	var total int64
 	for _, group := range groups {
-		total += group.Size * int64(len(group.Files)-1)
+		for _, file := range group.Wasted(policy) {
+			total += file.Size
+		}
 	}
 	return total
 }
+
+// Wasted returns the files whose deletion frees space when all but the one
+// policy keeps are deleted. Hard links to a file already returned, or to
+// the kept one, free nothing and are left out.
+func (g DuplicateGroup) Wasted(policy KeepPolicy) []scanner.FileInfo {
+	keep := g.KeepIndex(policy)
+	counted := []scanner.FileInfo{g.Files[keep]}
+	var wasted []scanner.FileInfo
+	for i, file := range g.Files {
+		if i == keep || slices.ContainsFunc(counted, file.SameFile) {
+			continue
+		}
+		counted = append(counted, file)
+		wasted = append(wasted, file)
+	}
+	return wasted
+}
+
+// distinctFiles counts the files that are not hard links to an earlier
+// member. Removing a hard link frees no space, and a group made only of
+// links to one inode is not a duplicate at all.
+func distinctFiles(files []scanner.FileInfo) int {
+	count := 0
+	for i, file := range files {
+		linked := false
+		for _, earlier := range files[:i] {
+			if file.SameFile(earlier) {
+				linked = true
+				break
+			}
+		}
+		if !linked {
+			count++
+		}
+	}
+	return count
+}
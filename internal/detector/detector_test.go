@@ -0,0 +1,45 @@
+//go:build linux || darwin
+
+package detector
+
+import (
+	"context"
+	"doppel/internal/scanner"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDistinctFilesCollapsesHardLinks(t *testing.T) {
+	root := t.TempDir()
+	original := filepath.Join(root, "a.txt")
+	if err := os.WriteFile(original, []byte("same content"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(original, filepath.Join(root, "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := scanner.ScanDirectory(context.Background(), root, scanner.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("scanned %d files, want 2", len(files))
+	}
+	if n := distinctFiles(files); n != 1 {
+		t.Errorf("hard link pair counts as %d files, want 1", n)
+	}
+
+	copied := filepath.Join(root, "c.txt")
+	if err := os.WriteFile(copied, []byte("same content"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	files, err = scanner.ScanDirectory(context.Background(), root, scanner.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := distinctFiles(files); n != 2 {
+		t.Errorf("hard link pair and a copy count as %d files, want 2", n)
+	}
+}
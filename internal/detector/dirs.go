@@ -0,0 +1,382 @@
+package detector
+
+import (
+	"crypto/sha256"
+	"doppel/internal/hasher"
+	"doppel/internal/scanner"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type DirectoryGroup struct {
+	Hash      string
+	Dirs      []string
+	Size      int64
+	FileCount int
+}
+
+type dirNode struct {
+	path     string
+	children []*dirNode
+	// hashes holds the content hashes of the files, and the names of empty
+	// files and symlinks.
+	hashes   []string
+	size     int64
+	count    int
+	complete bool
+	digest   string
+}
+
+// FindDuplicateDirs reports directories under roots whose whole subtree has
+// identical content. The digest of a directory is built from the content
+// hashes of its files and the digests of its subdirectories, so file names
+// do not matter, except for empty files and symlinks, which are compared by
+// name and target. Empty directories are ignored.
+//
+// files must be the complete scan of roots, with scanner.Options.ListAll:
+// a directory only gets a digest when every file below it has a content
+// hash in hashed and the scan left nothing out, i.e. it holds no pruned
+// directories or special files such as sockets and FIFOs.
+// Only the outermost duplicate directories are reported; their duplicated
+// subdirectories are implied.
+func FindDuplicateDirs(roots []string, files []scanner.FileInfo, hashed []hasher.HashedFile) []DirectoryGroup {
+	isRoot := make(map[string]bool)
+	for _, root := range roots {
+		isRoot[filepath.Clean(root)] = true
+	}
+
+	hashByPath := make(map[string]string)
+	for _, h := range hashed {
+		if h.Hash != "" {
+			hashByPath[h.FileInfo.Path] = h.Hash
+		}
+	}
+
+	nodes := make(map[string]*dirNode)
+	var getNode func(path string) *dirNode
+	getNode = func(path string) *dirNode {
+		if n, ok := nodes[path]; ok {
+			return n
+		}
+		n := &dirNode{path: path, complete: true}
+		nodes[path] = n
+		if parent := filepath.Dir(path); !isRoot[path] && parent != path {
+			p := getNode(parent)
+			p.children = append(p.children, n)
+		}
+		return n
+	}
+
+	for _, file := range files {
+		node := getNode(filepath.Dir(file.Path))
+		name := filepath.Base(file.Path)
+		switch {
+		case file.Type() == os.ModeSymlink:
+			node.hashes = append(node.hashes, "link:"+name+"\x00"+file.Link)
+			continue
+		case file.Mode.IsRegular() && file.Size == 0:
+			node.hashes = append(node.hashes, "empty:"+name)
+			continue
+		case !file.Mode.IsRegular() || file.Skipped:
+			node.complete = false
+			continue
+		}
+		node.size += file.Size
+		node.count++
+
+		if hash, ok := hashByPath[file.Path]; ok {
+			node.hashes = append(node.hashes, hash)
+		} else {
+			node.complete = false
+		}
+	}
+
+	for root := range isRoot {
+		if rootNode, ok := nodes[root]; ok {
+			computeDigest(rootNode)
+		}
+	}
+
+	byDigest := make(map[string][]*dirNode)
+	for _, n := range nodes {
+		if n.complete && n.count > 0 {
+			byDigest[n.digest] = append(byDigest[n.digest], n)
+		}
+	}
+
+	duplicated := make(map[string]string)
+	for digest, group := range byDigest {
+		if len(group) > 1 {
+			for _, n := range group {
+				duplicated[n.path] = digest
+			}
+		}
+	}
+
+	var groups []DirectoryGroup
+	for digest, group := range byDigest {
+		if len(group) < 2 || nestedGroup(group, isRoot, duplicated) {
+			continue
+		}
+
+		dirs := make([]string, 0, len(group))
+		for _, n := range group {
+			dirs = append(dirs, n.path)
+		}
+		sort.Strings(dirs)
+
+		groups = append(groups, DirectoryGroup{
+			Hash:      digest,
+			Dirs:      dirs,
+			Size:      group[0].size,
+			FileCount: group[0].count,
+		})
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Size != groups[j].Size {
+			return groups[i].Size > groups[j].Size
+		}
+		return groups[i].Dirs[0] < groups[j].Dirs[0]
+	})
+
+	return groups
+}
+
+// nestedGroup reports whether a group is implied by the group of its
+// parents: each directory sits in its own parent, and all the parents are
+// copies of each other.
+func nestedGroup(group []*dirNode, isRoot map[string]bool, duplicated map[string]string) bool {
+	parents := make(map[string]bool)
+	var parentDigest string
+	for _, n := range group {
+		parent := filepath.Dir(n.path)
+		digest, ok := duplicated[parent]
+		if isRoot[n.path] || !ok || parents[parent] {
+			return false
+		}
+		if parentDigest != "" && digest != parentDigest {
+			return false
+		}
+		parents[parent] = true
+		parentDigest = digest
+	}
+	return true
+}
+
+func computeDigest(n *dirNode) {
+	entries := make([]string, 0, len(n.hashes)+len(n.children))
+	for _, hash := range n.hashes {
+		entries = append(entries, "f:"+hash)
+	}
+
+	for _, child := range n.children {
+		computeDigest(child)
+		n.size += child.size
+		n.count += child.count
+		if !child.complete {
+			n.complete = false
+		}
+		entries = append(entries, "d:"+child.digest)
+	}
+
+	if !n.complete {
+		return
+	}
+
+	sort.Strings(entries)
+	sum := sha256.Sum256([]byte(strings.Join(entries, "\n")))
+	n.digest = hex.EncodeToString(sum[:])
+}
+
+// SuppressDirectoryFiles drops the files that a directory group already
+// accounts for. Files inside the first directory of each group stay, so
+// copies elsewhere in the tree are still reported against them.
+func SuppressDirectoryFiles(groups []DuplicateGroup, dirGroups []DirectoryGroup) []DuplicateGroup {
+	if len(dirGroups) == 0 {
+		return groups
+	}
+
+	var redundant []string
+	for _, dg := range dirGroups {
+		redundant = append(redundant, dg.Dirs[1:]...)
+	}
+
+	var result []DuplicateGroup
+	for _, group := range groups {
+		var files []scanner.FileInfo
+		for _, file := range group.Files {
+			if !withinAny(file.Path, redundant) {
+				files = append(files, file)
+			}
+		}
+
+		if len(files) < 2 {
+			continue
+		}
+		group.Files = files
+		result = append(result, group)
+	}
+
+	return result
+}
+
+func withinAny(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if strings.HasPrefix(path, dir+string(os.PathSeparator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func CalculateDirectoryWastedSpace(groups []DirectoryGroup) int64 {
+	var total int64
+	for _, group := range groups {
+		total += group.Size * int64(len(group.Dirs)-1)
+	}
+	return total
+}
+
+type DirectoryOverlap struct {
+	Dir1        string
+	Dir2        string
+	Overlap     int
+	SharedBytes int64
+	SharedCount int
+	Only1       []scanner.FileInfo
+	Only2       []scanner.FileInfo
+}
+
+// FindSimilarDirs compares the files directly inside each directory and
+// reports pairs whose Jaccard overlap of content hashes is at least
+// minOverlap percent. Files without a content hash are unique by definition.
+//
+// Pairs are found by prefix filtering: with the files of every directory
+// ordered from the rarest to the most common, two directories can only
+// overlap enough when the first n - ceil(minOverlap% * n) + 1 of their n
+// files have one in common. Files found in countless directories, such as
+// a LICENSE, sit at the end and rarely need to be looked at.
+func FindSimilarDirs(files []scanner.FileInfo, hashed []hasher.HashedFile, minOverlap int) []DirectoryOverlap {
+	hashByPath := make(map[string]string)
+	for _, h := range hashed {
+		if h.Hash != "" {
+			hashByPath[h.FileInfo.Path] = h.Hash
+		}
+	}
+
+	contents := make(map[string]map[string][]scanner.FileInfo)
+	for _, file := range files {
+		dir := filepath.Dir(file.Path)
+		key, ok := hashByPath[file.Path]
+		if !ok {
+			key = "path:" + file.Path
+		}
+		if contents[dir] == nil {
+			contents[dir] = make(map[string][]scanner.FileInfo)
+		}
+		contents[dir][key] = append(contents[dir][key], file)
+	}
+
+	dirsByHash := make(map[string][]string)
+	for dir, set := range contents {
+		for key := range set {
+			dirsByHash[key] = append(dirsByHash[key], dir)
+		}
+	}
+
+	dirs := make([]string, 0, len(contents))
+	for dir := range contents {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	type pair struct{ a, b string }
+	candidates := make(map[pair]bool)
+	index := make(map[string][]string)
+	for _, dir := range dirs {
+		keys := make([]string, 0, len(contents[dir]))
+		for key := range contents[dir] {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if fi, fj := len(dirsByHash[keys[i]]), len(dirsByHash[keys[j]]); fi != fj {
+				return fi < fj
+			}
+			return keys[i] < keys[j]
+		})
+
+		n := len(keys)
+		prefix := min(n, n-(minOverlap*n+99)/100+1)
+		for _, key := range keys[:prefix] {
+			for _, other := range index[key] {
+				candidates[pair{other, dir}] = true
+			}
+			index[key] = append(index[key], dir)
+		}
+	}
+
+	var overlaps []DirectoryOverlap
+	for p := range candidates {
+		set1, set2 := contents[p.a], contents[p.b]
+		smaller, larger := set1, set2
+		if len(smaller) > len(larger) {
+			smaller, larger = larger, smaller
+		}
+		count := 0
+		for key := range smaller {
+			if _, ok := larger[key]; ok {
+				count++
+			}
+		}
+		union := len(set1) + len(set2) - count
+		overlap := count * 100 / union
+		if overlap < minOverlap {
+			continue
+		}
+
+		o := DirectoryOverlap{
+			Dir1:        p.a,
+			Dir2:        p.b,
+			Overlap:     overlap,
+			SharedCount: count,
+		}
+		for key, files := range set1 {
+			if _, ok := set2[key]; ok {
+				o.SharedBytes += files[0].Size
+			} else {
+				o.Only1 = append(o.Only1, files...)
+			}
+		}
+		for key, files := range set2 {
+			if _, ok := set1[key]; !ok {
+				o.Only2 = append(o.Only2, files...)
+			}
+		}
+		sortByPath(o.Only1)
+		sortByPath(o.Only2)
+
+		overlaps = append(overlaps, o)
+	}
+
+	sort.Slice(overlaps, func(i, j int) bool {
+		if overlaps[i].Overlap != overlaps[j].Overlap {
+			return overlaps[i].Overlap > overlaps[j].Overlap
+		}
+		if overlaps[i].SharedBytes != overlaps[j].SharedBytes {
+			return overlaps[i].SharedBytes > overlaps[j].SharedBytes
+		}
+		return overlaps[i].Dir1 < overlaps[j].Dir1
+	})
+
+	return overlaps
+}
+
+func sortByPath(files []scanner.FileInfo) {
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+}
@@ -0,0 +1,123 @@
+package detector
+
+import (
+	"doppel/internal/hasher"
+	"doppel/internal/scanner"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+type dirFixture struct {
+	files  []scanner.FileInfo
+	hashed []hasher.HashedFile
+}
+
+func (f *dirFixture) add(path, hash string) {
+	file := scanner.FileInfo{Path: filepath.FromSlash(path), Size: 100}
+	f.files = append(f.files, file)
+	f.hashed = append(f.hashed, hasher.HashedFile{FileInfo: file, Hash: hash})
+}
+
+func TestFindSimilarDirsWidelySharedFile(t *testing.T) {
+	var f dirFixture
+	// Every directory holds the same LICENSE and a file of its own.
+	for i := range 20000 {
+		dir := fmt.Sprintf("/src/pkg%d", i)
+		f.add(dir+"/LICENSE", "license")
+		f.add(dir+"/main.go", fmt.Sprintf("main%d", i))
+	}
+	// Two of them also share nine more files: ten of twelve in common.
+	for i := range 9 {
+		f.add(fmt.Sprintf("/src/pkg0/util%d.go", i), fmt.Sprintf("util%d", i))
+		f.add(fmt.Sprintf("/src/pkg1/util%d.go", i), fmt.Sprintf("util%d", i))
+	}
+
+	overlaps := FindSimilarDirs(f.files, f.hashed, 80)
+	if len(overlaps) != 1 {
+		t.Fatalf("got %d overlapping pairs, want 1", len(overlaps))
+	}
+	o := overlaps[0]
+	if o.Dir1 != filepath.FromSlash("/src/pkg0") || o.Dir2 != filepath.FromSlash("/src/pkg1") {
+		t.Errorf("got pair %s, %s", o.Dir1, o.Dir2)
+	}
+	if o.SharedCount != 10 || o.Overlap != 83 {
+		t.Errorf("got %d shared files and %d%% overlap, want 10 and 83%%", o.SharedCount, o.Overlap)
+	}
+	if len(o.Only1) != 1 || len(o.Only2) != 1 {
+		t.Errorf("got %d and %d unique files, want 1 and 1", len(o.Only1), len(o.Only2))
+	}
+}
+
+func TestFindSimilarDirsManyCopies(t *testing.T) {
+	const copies = 150
+	var f dirFixture
+	for i := range copies {
+		for j := range 5 {
+			f.add(fmt.Sprintf("/backup%d/file%d", i, j), fmt.Sprintf("hash%d", j))
+		}
+	}
+
+	overlaps := FindSimilarDirs(f.files, f.hashed, 90)
+	if want := copies * (copies - 1) / 2; len(overlaps) != want {
+		t.Fatalf("got %d overlapping pairs, want %d", len(overlaps), want)
+	}
+	for _, o := range overlaps {
+		if o.Overlap != 100 || o.SharedCount != 5 {
+			t.Fatalf("%s and %s overlap by %d%% with %d shared files", o.Dir1, o.Dir2, o.Overlap, o.SharedCount)
+		}
+	}
+}
+
+// TestFindSimilarDirsMatchesAllPairs checks the prefix filter against
+// comparing every pair of directories.
+func TestFindSimilarDirsMatchesAllPairs(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var f dirFixture
+	for i := range 60 {
+		for j := range 1 + rng.Intn(12) {
+			f.add(fmt.Sprintf("/d%d/f%d", i, j), fmt.Sprintf("h%d", rng.Intn(15)))
+		}
+	}
+
+	sets := make(map[string]map[string]bool)
+	for _, h := range f.hashed {
+		dir := filepath.Dir(h.FileInfo.Path)
+		if sets[dir] == nil {
+			sets[dir] = make(map[string]bool)
+		}
+		sets[dir][h.Hash] = true
+	}
+
+	for _, minOverlap := range []int{0, 30, 50, 75, 90, 100} {
+		want := make(map[string]int)
+		for a, setA := range sets {
+			for b, setB := range sets {
+				if a >= b {
+					continue
+				}
+				count := 0
+				for key := range setA {
+					if setB[key] {
+						count++
+					}
+				}
+				overlap := count * 100 / (len(setA) + len(setB) - count)
+				if count > 0 && overlap >= minOverlap {
+					want[a+" "+b] = overlap
+				}
+			}
+		}
+
+		overlaps := FindSimilarDirs(f.files, f.hashed, minOverlap)
+		if len(overlaps) != len(want) {
+			t.Errorf("at %d%%: got %d pairs, want %d", minOverlap, len(overlaps), len(want))
+		}
+		for _, o := range overlaps {
+			if overlap, ok := want[o.Dir1+" "+o.Dir2]; !ok || overlap != o.Overlap {
+				t.Errorf("at %d%%: got %s and %s with %d%%, want %d%%", minOverlap, o.Dir1, o.Dir2, o.Overlap, overlap)
+			}
+		}
+	}
+}
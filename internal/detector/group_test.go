@@ -0,0 +1,64 @@
+package detector
+
+import (
+	"context"
+	"doppel/internal/hasher"
+	"doppel/internal/scanner"
+	"slices"
+	"testing"
+)
+
+func TestOrientationRelativeToKept(t *testing.T) {
+	// The second file is the first turned clockwise at twice the size,
+	// the third the first mirrored.
+	group := DuplicateGroup{
+		Files: []scanner.FileInfo{{Path: "small.jpg"}, {Path: "large.jpg"}, {Path: "mirrored.jpg"}},
+		Images: []hasher.ImageInfo{
+			{Width: 400, Height: 300},
+			{Width: 600, Height: 800},
+			{Width: 400, Height: 300},
+		},
+		Transforms: []hasher.Transform{hasher.Identity, hasher.Rotate90, hasher.FlipHorizontal},
+	}
+
+	keep := group.KeepIndex(KeepHighestResolution)
+	if keep != 1 {
+		t.Fatalf("kept file %d, want 1", keep)
+	}
+	want := []hasher.Transform{hasher.Rotate270, hasher.Identity, hasher.Transverse}
+	for i, transform := range want {
+		if got := group.Orientation(i, keep); got != transform {
+			t.Errorf("file %d is %v from the kept one, want %v", i, got, transform)
+		}
+	}
+
+	for i, transform := range group.Transforms {
+		if got := group.Orientation(i, 0); got != transform {
+			t.Errorf("file %d is %v from the first, want %v", i, got, transform)
+		}
+	}
+}
+
+func TestAudioGroupTagDiff(t *testing.T) {
+	file := func(path, audio string, tags hasher.Tags) hasher.HashedFile {
+		return hasher.HashedFile{
+			FileInfo: scanner.FileInfo{Path: path, Size: int64(100 + len(path))},
+			IsAudio:  true,
+			Audio:    audio,
+			Tags:     tags,
+		}
+	}
+	hashed := []hasher.HashedFile{
+		file("a.mp3", "sha256:1", hasher.Tags{"tags": "ID3v2.3", "title": "Song", "artist": "Band"}),
+		file("bb.mp3", "sha256:1", hasher.Tags{"tags": "ID3v2.3+ID3v1", "title": "Song (remaster)", "artist": "Band"}),
+		file("ccc.mp3", "sha256:2", hasher.Tags{"title": "Other"}),
+	}
+
+	groups := FindDuplicates(context.Background(), hashed, 5, 10)
+	if len(groups) != 1 || len(groups[0].Files) != 2 || !groups[0].IsAudio {
+		t.Fatalf("got groups %+v, want one audio group of two files", groups)
+	}
+	if got, want := groups[0].TagDiff(), []string{"tags", "title"}; !slices.Equal(got, want) {
+		t.Errorf("tags differ in %v, want %v", got, want)
+	}
+}
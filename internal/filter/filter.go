@@ -0,0 +1,166 @@
+package filter
+
+import (
+	"doppel/internal/scanner"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Options struct {
+	MinSize    int64
+	MaxSize    int64
+	Extensions []string
+	NewerThan  time.Time
+	OlderThan  time.Time
+}
+
+func (o Options) IsEmpty() bool {
+	return o.MinSize == 0 && o.MaxSize == 0 && len(o.Extensions) == 0 && o.NewerThan.IsZero() && o.OlderThan.IsZero()
+}
+
+func (o Options) Apply(files []scanner.FileInfo) []scanner.FileInfo {
+	if o.IsEmpty() {
+		return files
+	}
+
+	filtered := make([]scanner.FileInfo, 0)
+	for _, file := range files {
+		if o.Match(file) {
+			filtered = append(filtered, file)
+		}
+	}
+
+	return filtered
+}
+
+func (o Options) Match(file scanner.FileInfo) bool {
+	if o.MinSize > 0 && file.Size < o.MinSize {
+		return false
+	}
+
+	if o.MaxSize > 0 && file.Size > o.MaxSize {
+		return false
+	}
+
+	if !o.NewerThan.IsZero() && !file.ModTime.After(o.NewerThan) {
+		return false
+	}
+
+	if !o.OlderThan.IsZero() && !file.ModTime.Before(o.OlderThan) {
+		return false
+	}
+
+	if len(o.Extensions) > 0 {
+		ext := strings.ToLower(filepath.Ext(file.Path))
+		for _, allowedExt := range o.Extensions {
+			if strings.ToLower(allowedExt) == ext {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+var sizeUnits = map[string]float64{
+	"":    1,
+	"b":   1,
+	"k":   1e3,
+	"kb":  1e3,
+	"m":   1e6,
+	"mb":  1e6,
+	"g":   1e9,
+	"gb":  1e9,
+	"t":   1e12,
+	"tb":  1e12,
+	"p":   1e15,
+	"pb":  1e15,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+	"pib": 1 << 50,
+}
+
+// ParseSize parses a byte count such as "1048576", "10MB" or "4GiB". SI
+// suffixes (KB, MB, ...) are powers of 1000, IEC suffixes (KiB, MiB, ...)
+// powers of 1024. Units are case-insensitive and fractions are allowed.
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i == -1 {
+		i = len(s)
+	}
+
+	number, unit := s[:i], strings.ToLower(strings.TrimSpace(s[i:]))
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	multiplier, ok := sizeUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q: unknown unit %q", s, s[i:])
+	}
+
+	bytes := value * multiplier
+	// float64(math.MaxInt64) rounds up to 2^63, which no int64 holds.
+	if bytes >= math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size %q: too large", s)
+	}
+
+	return int64(bytes), nil
+}
+
+var dateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	time.RFC3339,
+}
+
+// ParseTime parses either an age relative to now ("30d", "2w", "12h",
+// "90m") or an absolute date ("2024-01-01", RFC 3339). Dates without a zone
+// are taken in local time.
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	age, err := parseAge(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: use an age like 30d or a date like 2024-01-01", s)
+	}
+
+	return now.Add(-age), nil
+}
+
+func parseAge(s string) (time.Duration, error) {
+	days := map[string]float64{"d": 1, "w": 7, "y": 365}
+	if n := len(s); n > 1 {
+		if factor, ok := days[s[n-1:]]; ok {
+			value, err := strconv.ParseFloat(s[:n-1], 64)
+			if err != nil || value < 0 {
+				return 0, fmt.Errorf("invalid age %q", s)
+			}
+			return time.Duration(value * factor * float64(24*time.Hour)), nil
+		}
+	}
+
+	age, err := time.ParseDuration(s)
+	if err != nil || age < 0 {
+		return 0, fmt.Errorf("invalid age %q", s)
+	}
+	return age, nil
+}
@@ -0,0 +1,79 @@
+package hasher
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"strings"
+
+	"github.com/zeebo/blake3"
+	"github.com/zeebo/xxh3"
+)
+
+// Hasher is a content hash algorithm. Hashes are written as "name:hex", so
+// hashes made with different algorithms never compare equal, whether they
+// come from this run or from a checkpoint.
+type Hasher interface {
+	Name() string
+	New() hash.Hash
+}
+
+type namedHasher struct {
+	name string
+	new  func() hash.Hash
+}
+
+func (h namedHasher) Name() string   { return h.name }
+func (h namedHasher) New() hash.Hash { return h.new() }
+
+var (
+	// SHA256 is the default: slowest, but the one other tools can check.
+	SHA256 Hasher = namedHasher{"sha256", sha256.New}
+	// BLAKE3 is cryptographic too and several times faster on modern CPUs.
+	BLAKE3 Hasher = namedHasher{"blake3", func() hash.Hash { return blake3.New() }}
+	// XXH3 is xxh3-128, the fastest. It is not cryptographic, so a crafted
+	// collision could make two different files look identical.
+	XXH3 Hasher = namedHasher{"xxh3", func() hash.Hash { return &xxh3Sum128{xxh3.New()} }}
+)
+
+var Hashers = []Hasher{SHA256, BLAKE3, XXH3}
+
+// HasherByName returns the algorithm called name.
+func HasherByName(name string) (Hasher, error) {
+	for _, h := range Hashers {
+		if h.Name() == name {
+			return h, nil
+		}
+	}
+
+	names := make([]string, len(Hashers))
+	for i, h := range Hashers {
+		names[i] = h.Name()
+	}
+	return nil, fmt.Errorf("unknown hash %q, must be one of %s", name, strings.Join(names, "|"))
+}
+
+// xxh3Sum128 makes xxh3.Hasher produce its 128-bit digest; its own Sum
+// returns the 64-bit one.
+type xxh3Sum128 struct {
+	*xxh3.Hasher
+}
+
+func (h *xxh3Sum128) Size() int { return 16 }
+
+func (h *xxh3Sum128) Sum(b []byte) []byte {
+	sum := h.Sum128().Bytes()
+	return append(b, sum[:]...)
+}
+
+func (o Options) hasher() Hasher {
+	if o.Hasher == nil {
+		return SHA256
+	}
+	return o.Hasher
+}
+
+// MadeWith reports whether a content hash was computed with h.
+func MadeWith(hash string, h Hasher) bool {
+	return strings.HasPrefix(hash, h.Name()+":")
+}
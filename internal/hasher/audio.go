@@ -0,0 +1,201 @@
+package hasher
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var audioExtensions = map[string]bool{
+	".mp3":  true,
+	".flac": true,
+}
+
+func isAudio(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	return audioExtensions[ext]
+}
+
+// audioTypes are the MIME types of the formats audio mode understands.
+var audioTypes = map[string]bool{
+	"audio/mpeg": true,
+	"audio/flac": true,
+}
+
+// flacMD5Prefix marks audio hashes taken from the MD5 of the decoded
+// samples that FLAC encoders store in STREAMINFO. They do not depend on
+// Options.Hasher, and match across compression levels.
+const flacMD5Prefix = "flac-md5:"
+
+var errBadAudio = errors.New("cannot read audio")
+
+// maxTagSize bounds the tags read into memory. Larger ones, which hold
+// embedded pictures, are still skipped when hashing but not parsed.
+const maxTagSize = 16 << 20
+
+// hashAudio sets h.Audio to a hash of the audio of an MP3 or FLAC file,
+// leaving out ID3v2 tags at the start and ID3v1 and APEv2 tags at the end,
+// and h.Tags to the metadata found in them. For FLAC, the metadata blocks
+// are left out as well, and the STREAMINFO MD5 is used when the encoder set
+// it. With Options.Fingerprint, FLAC and WAV files are decoded and
+// fingerprinted instead. It returns how many bytes it read through a job
+// reader, which reports them to Options.Progress. Files that are none of
+// these give errBadAudio.
+func hashAudio(ctx context.Context, h *HashedFile, file *os.File, opts Options) (int64, error) {
+	size := h.FileInfo.Size
+	tags := Tags{}
+
+	var start int64
+	for {
+		header, err := readAt(ctx, file, start, 10, opts)
+		if err != nil {
+			break
+		}
+		n := id3v2Size(header)
+		if n == 0 || start+n > size {
+			break
+		}
+		if n <= maxTagSize {
+			if tag, err := readAt(ctx, file, start, n, opts); err == nil {
+				parseID3v2(tag, tags)
+			}
+		}
+		start += n
+	}
+
+	end := size
+	for found := true; found; {
+		found = false
+		if end-start >= id3v1Size {
+			if tag, err := readAt(ctx, file, end-id3v1Size, id3v1Size, opts); err == nil && bytes.HasPrefix(tag, []byte("TAG")) {
+				parseID3v1(tag, tags)
+				end -= id3v1Size
+				found = true
+			}
+		}
+		if end-start >= apeFooterSize {
+			footer, err := readAt(ctx, file, end-apeFooterSize, apeFooterSize, opts)
+			if n := apeSize(footer); err == nil && n > 0 && n <= end-start {
+				if n <= maxTagSize {
+					if tag, err := readAt(ctx, file, end-n, n, opts); err == nil {
+						parseAPE(tag, tags)
+					}
+				}
+				end -= n
+				found = true
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	header, err := readAt(ctx, file, start, 4, opts)
+	switch {
+	case err != nil:
+		return 0, errBadAudio
+	case string(header) == "fLaC":
+		// Sniffing saw the ID3v2 tag some FLAC files start with.
+		h.MIME = "audio/flac"
+		var info streamInfo
+		start, info, err = flacMetadata(ctx, file, start, end, tags, opts)
+		if err != nil {
+			return 0, err
+		}
+		h.Tags = tags
+		if opts.Fingerprint {
+			return fingerprintFLAC(ctx, h, file, start, end, info, opts)
+		}
+		if !bytes.Equal(info.MD5, make([]byte, len(info.MD5))) {
+			h.Audio = flacMD5Prefix + hex.EncodeToString(info.MD5)
+			return 0, nil
+		}
+	case string(header) == "RIFF" && opts.Fingerprint:
+		h.Tags = tags
+		return fingerprintWAV(ctx, h, file, start, end, opts)
+	case isMPEGFrame(header):
+		h.Tags = tags
+	default:
+		return 0, errBadAudio
+	}
+	if end <= start {
+		return 0, errBadAudio
+	}
+
+	algorithm := opts.hasher()
+	hasher := algorithm.New()
+	buf := make([]byte, readBufferSize)
+	if _, err := io.CopyBuffer(hasher, newJobReader(ctx, io.NewSectionReader(file, start, end-start), opts), buf); err != nil {
+		return 0, err
+	}
+	h.Audio = algorithm.Name() + ":" + hex.EncodeToString(hasher.Sum(nil))
+	return end - start, nil
+}
+
+// flacMetadata reads the metadata blocks of a FLAC stream whose marker is at
+// start. It returns where the audio frames begin and the STREAMINFO, and
+// adds Vorbis comments to tags.
+func flacMetadata(ctx context.Context, file *os.File, start, end int64, tags Tags, opts Options) (int64, streamInfo, error) {
+	var info []byte
+	pos := start + 4
+	for {
+		header, err := readAt(ctx, file, pos, 4, opts)
+		if err != nil {
+			return 0, streamInfo{}, errBadAudio
+		}
+		last, kind := header[0]&0x80 != 0, header[0]&0x7F
+		length := int64(binary.BigEndian.Uint32(header) & 0xFFFFFF)
+		if pos+4+length > end {
+			return 0, streamInfo{}, errBadAudio
+		}
+
+		switch {
+		case kind == 0 && length >= 34:
+			block, err := readAt(ctx, file, pos+4, 34, opts)
+			if err != nil {
+				return 0, streamInfo{}, errBadAudio
+			}
+			info = block
+		case kind == 4 && length <= maxTagSize:
+			if block, err := readAt(ctx, file, pos+4, length, opts); err == nil {
+				parseVorbisComment(block, tags)
+			}
+		}
+
+		pos += 4 + length
+		if last {
+			break
+		}
+	}
+	if info == nil {
+		return 0, streamInfo{}, errBadAudio
+	}
+	return pos, parseStreamInfo(info), nil
+}
+
+// readAt reads n bytes at off, within the read limits but without counting
+// them as progress.
+func readAt(ctx context.Context, file *os.File, off, n int64, opts Options) ([]byte, error) {
+	quiet := opts
+	quiet.Progress = nil
+	buf := make([]byte, n)
+	_, err := io.ReadFull(newJobReader(ctx, io.NewSectionReader(file, off, n), quiet), buf)
+	return buf, err
+}
+
+// isMPEGFrame reports whether data starts with a valid MPEG audio frame
+// header.
+func isMPEGFrame(data []byte) bool {
+	if len(data) < 4 || data[0] != 0xFF || data[1]&0xE0 != 0xE0 {
+		return false
+	}
+	version, layer := data[1]>>3&3, data[1]>>1&3
+	bitrate, sampleRate := data[2]>>4, data[2]>>2&3
+	return version != 1 && layer != 0 && bitrate != 15 && sampleRate != 3
+}
@@ -0,0 +1,155 @@
+package hasher
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"doppel/internal/scanner"
+	"encoding/binary"
+	"encoding/hex"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// mpegFrames returns a few MPEG-1 Layer III frames at 128 kbit/s and
+// 44.1 kHz, with made-up contents.
+func mpegFrames() []byte {
+	const frameSize = 417
+	var data []byte
+	for i := range 5 {
+		frame := make([]byte, frameSize)
+		copy(frame, []byte{0xFF, 0xFB, 0x90, 0x64})
+		for j := 4; j < frameSize; j++ {
+			frame[j] = byte(i*31 + j%251)
+		}
+		data = append(data, frame...)
+	}
+	return data
+}
+
+// flacFile builds a FLAC file with a STREAMINFO block holding md5, a
+// VORBIS_COMMENT block and frames as its audio.
+func flacFile(md5 []byte, frames []byte, comments ...string) []byte {
+	info := streamInfoBlock(44100, 2, 16, md5)
+	vorbis := vorbisComment(comments...)
+
+	data := []byte("fLaC")
+	data = append(data, 0, 0, 0, byte(len(info)))
+	data = append(data, info...)
+	data = binary.BigEndian.AppendUint32(data, 0x84<<24|uint32(len(vorbis)))
+	data = append(data, vorbis...)
+	return append(data, frames...)
+}
+
+// hashFiles writes each of contents to a file of its own, named a, b, c
+// and so on with extension ext, and hashes them with opts.
+func hashFiles(t *testing.T, ext string, opts Options, contents ...[]byte) []HashedFile {
+	t.Helper()
+	dir := t.TempDir()
+	var files []scanner.FileInfo
+	for i, content := range contents {
+		path := filepath.Join(dir, string(rune('a'+i))+ext)
+		if err := os.WriteFile(path, content, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, scanner.FileInfo{Path: path, Size: info.Size(), Mode: info.Mode(), ModTime: info.ModTime()})
+	}
+
+	hashed := HashFiles(context.Background(), files, opts)
+	SortByPath(hashed)
+	return hashed
+}
+
+// hashAudioFiles hashes contents in audio mode, where each must be
+// recognised as audio.
+func hashAudioFiles(t *testing.T, ext string, contents ...[]byte) []HashedFile {
+	t.Helper()
+	hashed := hashFiles(t, ext, Options{Audio: true}, contents...)
+	if len(hashed) != len(contents) {
+		t.Fatalf("hashed %d of %d files", len(hashed), len(contents))
+	}
+	for _, h := range hashed {
+		if !h.IsAudio {
+			t.Fatalf("%s was not hashed as audio", filepath.Base(h.FileInfo.Path))
+		}
+	}
+	return hashed
+}
+
+func TestHashAudioLeavesOutTags(t *testing.T) {
+	frames := mpegFrames()
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+	hashed := hashAudioFiles(t, ".mp3",
+		frames,
+		join(id3v2Tag(3, 0, id3v2Frame(3, "TIT2", latin1Text("First title"))), frames),
+		join(
+			id3v2Tag(4, 0, id3v2Frame(4, "TIT2", latin1Text("Second title"))),
+			id3v2Tag(3, 0, id3v2Frame(3, "TPE1", latin1Text("Artist"))),
+			frames,
+			apeTag(true, apeItem{"Album", "Album", 0}),
+			id3v1Tag("Third title", "", "", "", "", 1, 0xFF),
+		),
+		join(frames, id3v1Tag("Fourth title", "", "", "", "", 0, 0xFF), apeTag(false, apeItem{"Title", "Fifth title", 0})),
+	)
+
+	sum := sha256.Sum256(frames)
+	want := "sha256:" + hex.EncodeToString(sum[:])
+	wantTags := []Tags{
+		{},
+		{"tags": "ID3v2.3", "title": "First title"},
+		{"tags": "ID3v2.4+ID3v2.3+ID3v1+APEv2", "title": "Second title", "artist": "Artist", "album": "Album", "track": "1"},
+		{"tags": "APEv2+ID3v1", "title": "Fifth title"},
+	}
+	for i, h := range hashed {
+		if h.Audio != want {
+			t.Errorf("file %d: audio hash %s, want the hash of the frames %s", i, h.Audio, want)
+		}
+		if !maps.Equal(h.Tags, wantTags[i]) {
+			t.Errorf("file %d: got tags %v, want %v", i, h.Tags, wantTags[i])
+		}
+	}
+
+	// Different frames with the same tags do not match.
+	other := bytes.Clone(frames)
+	other[len(other)/2]++
+	tag := id3v2Tag(3, 0, id3v2Frame(3, "TIT2", latin1Text("First title")))
+	changed := hashAudioFiles(t, ".mp3", join(tag, frames), join(tag, other))
+	if changed[0].Audio == changed[1].Audio {
+		t.Error("different frames have the same audio hash")
+	}
+}
+
+func TestHashAudioFLAC(t *testing.T) {
+	md5 := bytes.Repeat([]byte{0xAB}, 16)
+	frames := []byte("\xff\xf8 not really FLAC frames")
+	hashed := hashAudioFiles(t, ".flac",
+		flacFile(md5, frames, "TITLE=One"),
+		flacFile(md5, frames, "TITLE=Two", "ARTIST=Someone"),
+		append(id3v2Tag(3, 0, id3v2Frame(3, "TIT2", latin1Text("Three"))), flacFile(md5, frames)...),
+		flacFile(make([]byte, 16), frames, "TITLE=Four"),
+	)
+
+	want := flacMD5Prefix + hex.EncodeToString(md5)
+	for i, h := range hashed[:3] {
+		if h.Audio != want {
+			t.Errorf("file %d: audio hash %s, want %s", i, h.Audio, want)
+		}
+	}
+	if hashed[0].Tags["title"] != "One" || hashed[1].Tags["title"] != "Two" || hashed[2].Tags["title"] != "Three" {
+		t.Errorf("got titles %q, %q and %q", hashed[0].Tags["title"], hashed[1].Tags["title"], hashed[2].Tags["title"])
+	}
+
+	// Without an MD5 the frames are hashed.
+	sum := sha256.Sum256(frames)
+	if want := "sha256:" + hex.EncodeToString(sum[:]); hashed[3].Audio != want {
+		t.Errorf("audio hash %s without an MD5, want %s", hashed[3].Audio, want)
+	}
+}
@@ -0,0 +1,80 @@
+package hasher
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// exifSearchSize is how much of a file is searched for EXIF metadata. The
+// APP1 segment holding it is at most 64 KiB and comes right after the
+// start of the image, possibly behind a JFIF segment.
+const exifSearchSize = 128 << 10
+
+const orientationTag = 0x0112
+
+// exifTransforms maps the EXIF Orientation values 1 to 8 to the transform
+// that turns the stored pixels into the image as it should be displayed.
+var exifTransforms = [...]Transform{
+	1: Identity,
+	2: FlipHorizontal,
+	3: Rotate180,
+	4: FlipVertical,
+	5: Transpose,
+	6: Rotate90,
+	7: Transverse,
+	8: Rotate270,
+}
+
+// jpegOrientation reads the EXIF Orientation tag from the start of a JPEG
+// file. It returns Identity when data is not a JPEG, has no EXIF metadata
+// or the tag is missing or invalid.
+func jpegOrientation(data []byte) Transform {
+	for marker, payload := range jpegSegments(data) {
+		if marker == 0xE1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+			return exifOrientation(payload[6:])
+		}
+	}
+	return Identity
+}
+
+// exifOrientation finds the Orientation tag in the first IFD of the TIFF
+// structure that EXIF metadata is stored in.
+func exifOrientation(tiff []byte) Transform {
+	if len(tiff) < 8 {
+		return Identity
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return Identity
+	}
+	if order.Uint16(tiff[2:]) != 42 {
+		return Identity
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return Identity
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := range count {
+		entry := ifd + 2 + 12*i
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) != orientationTag {
+			continue
+		}
+		// The value is a SHORT stored in the first bytes of the value field.
+		value := int(order.Uint16(tiff[entry+8:]))
+		if order.Uint16(tiff[entry+2:]) != 3 || value < 1 || value >= len(exifTransforms) {
+			return Identity
+		}
+		return exifTransforms[value]
+	}
+	return Identity
+}
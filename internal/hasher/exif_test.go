@@ -0,0 +1,124 @@
+package hasher
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// exifJPEG builds the start of a JPEG file: a JFIF segment, then an APP1
+// segment from exifPayload.
+func exifJPEG(order binary.ByteOrder, tagType uint16, value uint16) []byte {
+	jfif := []byte("JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00")
+
+	data := []byte{0xFF, 0xD8}
+	data = appendSegment(data, 0xE0, jfif)
+	data = appendSegment(data, 0xE1, exifPayload(order, tagType, value))
+	return append(data, 0xFF, 0xD9)
+}
+
+// exifPayload builds the payload of an APP1 segment whose first IFD holds a
+// Make tag and the Orientation tag with the given type and value.
+func exifPayload(order binary.ByteOrder, tagType uint16, value uint16) []byte {
+	tiff := make([]byte, 8+2+2*12+4)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 2)
+
+	makeTag := tiff[10:]
+	order.PutUint16(makeTag, 0x010F)
+	order.PutUint16(makeTag[2:], 2)
+	order.PutUint32(makeTag[4:], 4)
+	copy(makeTag[8:], "abc\x00")
+
+	orientation := tiff[22:]
+	order.PutUint16(orientation, orientationTag)
+	order.PutUint16(orientation[2:], tagType)
+	order.PutUint32(orientation[4:], 1)
+	order.PutUint16(orientation[8:], value)
+
+	return append([]byte("Exif\x00\x00"), tiff...)
+}
+
+func appendSegment(data []byte, marker byte, payload []byte) []byte {
+	data = append(data, 0xFF, marker)
+	data = binary.BigEndian.AppendUint16(data, uint16(len(payload)+2))
+	return append(data, payload...)
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	// A 3x2 image with a red pixel in its first stored corner and a green
+	// one next to it along the first row. The EXIF value says where the
+	// first row and column are shown, which places both pixels.
+	tests := []struct {
+		value         uint16
+		want          Transform
+		width, height int
+		red, green    image.Point
+	}{
+		{1, Identity, 3, 2, image.Pt(0, 0), image.Pt(1, 0)},
+		{2, FlipHorizontal, 3, 2, image.Pt(2, 0), image.Pt(1, 0)},
+		{3, Rotate180, 3, 2, image.Pt(2, 1), image.Pt(1, 1)},
+		{4, FlipVertical, 3, 2, image.Pt(0, 1), image.Pt(1, 1)},
+		{5, Transpose, 2, 3, image.Pt(0, 0), image.Pt(0, 1)},
+		{6, Rotate90, 2, 3, image.Pt(1, 0), image.Pt(1, 1)},
+		{7, Transverse, 2, 3, image.Pt(1, 2), image.Pt(1, 1)},
+		{8, Rotate270, 2, 3, image.Pt(0, 2), image.Pt(0, 1)},
+	}
+
+	red := color.RGBA{R: 255, A: 255}
+	green := color.RGBA{G: 255, A: 255}
+	stored := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for i := range stored.Pix {
+		stored.Pix[i] = 255
+	}
+	stored.Set(0, 0, red)
+	stored.Set(1, 0, green)
+
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		for _, tt := range tests {
+			got := jpegOrientation(exifJPEG(order, 3, tt.value))
+			if got != tt.want {
+				t.Errorf("%v orientation %d: got %v, want %v", order, tt.value, got, tt.want)
+				continue
+			}
+
+			img := got.apply(stored)
+			bounds := img.Bounds()
+			if bounds.Dx() != tt.width || bounds.Dy() != tt.height {
+				t.Errorf("orientation %d: got %dx%d, want %dx%d", tt.value, bounds.Dx(), bounds.Dy(), tt.width, tt.height)
+				continue
+			}
+			if c := img.At(tt.red.X, tt.red.Y); c != red {
+				t.Errorf("orientation %d: pixel %v is %v, want red", tt.value, tt.red, c)
+			}
+			if c := img.At(tt.green.X, tt.green.Y); c != green {
+				t.Errorf("orientation %d: pixel %v is %v, want green", tt.value, tt.green, c)
+			}
+		}
+	}
+}
+
+func TestJPEGOrientationInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"value 0", exifJPEG(binary.BigEndian, 3, 0)},
+		{"value 9", exifJPEG(binary.LittleEndian, 3, 9)},
+		{"LONG type", exifJPEG(binary.LittleEndian, 4, 6)},
+		{"truncated", exifJPEG(binary.BigEndian, 3, 6)[:40]},
+		{"not a JPEG", []byte("GIF89a")},
+	}
+	for _, tt := range tests {
+		if got := jpegOrientation(tt.data); got != Identity {
+			t.Errorf("%s: got %v, want %v", tt.name, got, Identity)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package hasher
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+	"math/cmplx"
+	"path/filepath"
+	"strings"
+)
+
+var fingerprintExtensions = map[string]bool{
+	".wav":  true,
+	".flac": true,
+}
+
+func isFingerprintable(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	return fingerprintExtensions[ext]
+}
+
+// fingerprintTypes are the MIME types of the formats that are decoded for
+// an acoustic fingerprint.
+var fingerprintTypes = map[string]bool{
+	"audio/wave": true,
+	"audio/flac": true,
+}
+
+const (
+	// fingerprintRate is the sample rate audio is mixed down to. It keeps
+	// every note up to fingerprintMaxFreq.
+	fingerprintRate = 11025
+	// fingerprintFrame and fingerprintHop are the length of the analysed
+	// frames and the distance between them, in samples: about a third of a
+	// second, and an eighth.
+	fingerprintFrame = 4096
+	fingerprintHop   = fingerprintFrame / 3
+	// Frequencies outside this range, in Hz, are left out of the chroma.
+	fingerprintMinFreq = 28
+	fingerprintMaxFreq = 3520
+	// maxFingerprintSeconds bounds how much of a track is decoded. Like
+	// other fingerprinters, only the start of a track is compared.
+	maxFingerprintSeconds = 120
+	// minFingerprintFrames is the shortest fingerprint, about a second,
+	// that is compared at all.
+	minFingerprintFrames = 8
+	// maxFingerprintShift is how many frames, about two seconds, one
+	// fingerprint may be shifted against another to line them up.
+	maxFingerprintShift = 16
+)
+
+// FingerprintAlgorithm names the acoustic fingerprint, which is computed
+// from the chroma of the decoded audio: how its energy is spread over the
+// twelve pitch classes.
+const FingerprintAlgorithm = "chroma"
+
+// Fingerprint is an acoustic fingerprint of a track, one 32-bit code for
+// each frame of its start. Each bit compares the energy of two pitch
+// classes, so the codes survive resampling, a change of bit depth or
+// volume, and mild lossy coding. Silent frames code as zero, which no
+// sounding frame does.
+type Fingerprint []uint32
+
+func (f Fingerprint) String() string {
+	raw := make([]byte, 4*len(f))
+	for i, code := range f {
+		binary.LittleEndian.PutUint32(raw[4*i:], code)
+	}
+	return FingerprintAlgorithm + ":" + base64.RawStdEncoding.EncodeToString(raw)
+}
+
+// ParseFingerprint reads a fingerprint written by Fingerprint.String.
+func ParseFingerprint(s string) (Fingerprint, error) {
+	name, data, ok := strings.Cut(s, ":")
+	if !ok || name != FingerprintAlgorithm {
+		return nil, fmt.Errorf("invalid fingerprint %q", s)
+	}
+	raw, err := base64.RawStdEncoding.DecodeString(data)
+	if err != nil || len(raw) == 0 || len(raw)%4 != 0 {
+		return nil, fmt.Errorf("invalid fingerprint %q", s)
+	}
+
+	f := make(Fingerprint, len(raw)/4)
+	for i := range f {
+		f[i] = binary.LittleEndian.Uint32(raw[4*i:])
+	}
+	return f, nil
+}
+
+// FingerprintDistance compares two fingerprints at the alignment where
+// they agree most, and returns the percentage of bits that differ there:
+// 0 for the same audio, around 50 for unrelated tracks. It returns false
+// when the fingerprints overlap by less than nine tenths of the longer
+// one, so tracks of clearly different lengths never match. Frames where both
+// tracks are silent are left out, so a shared silent intro does not make
+// two tracks alike; at least minFingerprintFrames others must remain.
+func FingerprintDistance(a, b Fingerprint) (float64, bool) {
+	longer := max(len(a), len(b))
+	best, found := 0.0, false
+	for shift := -maxFingerprintShift; shift <= maxFingerprintShift; shift++ {
+		// a[i] lines up with b[i+shift].
+		lo, hi := max(0, -shift), min(len(a), len(b)-shift)
+		n := hi - lo
+		if n < minFingerprintFrames || n*10 < longer*9 {
+			continue
+		}
+
+		differing, compared := 0, 0
+		for i := lo; i < hi; i++ {
+			if a[i] == 0 && b[i+shift] == 0 {
+				continue
+			}
+			differing += bits.OnesCount32(a[i] ^ b[i+shift])
+			compared++
+		}
+		if compared < minFingerprintFrames {
+			continue
+		}
+		distance := float64(differing) * 100 / float64(32*compared)
+		if !found || distance < best {
+			best, found = distance, true
+		}
+	}
+	return best, found
+}
+
+// setFingerprint fingerprints mono samples at fingerprintRate. Audio too
+// short to fingerprint, or with less than that much sound in it, gives
+// errNotMedia, so it is compared byte for byte.
+func (h *HashedFile) setFingerprint(samples []float32) error {
+	f := chromaFingerprint(samples)
+	sounding := 0
+	for _, code := range f {
+		if code != 0 {
+			sounding++
+		}
+	}
+	if sounding < minFingerprintFrames {
+		return errNotMedia
+	}
+	h.Fingerprint = f
+	return nil
+}
+
+// chromaFingerprint computes the chroma of every frame, smooths it over
+// five frames against noise, and codes each frame from comparisons between
+// its pitch classes. Silence codes as zero.
+func chromaFingerprint(samples []float32) Fingerprint {
+	window := make([]float64, fingerprintFrame)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/fingerprintFrame)
+	}
+	var pitchClass [fingerprintFrame / 2]int
+	for k := range pitchClass {
+		freq := float64(k) * fingerprintRate / fingerprintFrame
+		pitchClass[k] = -1
+		if freq >= fingerprintMinFreq && freq <= fingerprintMaxFreq {
+			note := int(math.Round(12 * math.Log2(freq/440)))
+			pitchClass[k] = (note%12 + 12) % 12
+		}
+	}
+
+	var chroma [][12]float64
+	buf := make([]complex128, fingerprintFrame)
+	for start := 0; start+fingerprintFrame <= len(samples); start += fingerprintHop {
+		for i := range buf {
+			buf[i] = complex(float64(samples[start+i])*window[i], 0)
+		}
+		fft(buf)
+
+		var c [12]float64
+		for k, class := range pitchClass {
+			if class >= 0 {
+				m := cmplx.Abs(buf[k])
+				c[class] += m * m
+			}
+		}
+		chroma = append(chroma, normalizeChroma(c))
+	}
+
+	f := make(Fingerprint, len(chroma))
+	for t := range chroma {
+		var c [12]float64
+		for s := max(0, t-2); s <= min(len(chroma)-1, t+2); s++ {
+			for i := range c {
+				c[i] += chroma[s][i]
+			}
+		}
+
+		var code uint32
+		for i := range 12 {
+			if c[i] > c[(i+1)%12] {
+				code |= 1 << i
+			}
+			if c[i] > c[(i+7)%12] {
+				code |= 1 << (12 + i)
+			}
+		}
+		for i := range 8 {
+			if c[i]+c[i+1] > c[(i+6)%12]+c[(i+7)%12] {
+				code |= 1 << (24 + i)
+			}
+		}
+		f[t] = code
+	}
+	return f
+}
+
+// normalizeChroma scales a chroma vector to unit length, so only the
+// balance between pitch classes counts. Near silence becomes all zeros.
+func normalizeChroma(c [12]float64) [12]float64 {
+	var norm float64
+	for _, v := range c {
+		norm += v * v
+	}
+	norm = math.Sqrt(norm)
+	if norm < 1e-6 {
+		return [12]float64{}
+	}
+	for i := range c {
+		c[i] /= norm
+	}
+	return c
+}
+
+// fft transforms x in place. Its length must be a power of two.
+func fft(x []complex128) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j |= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := range size / 2 {
+				u, v := x[start+k], x[start+k+size/2]*w
+				x[start+k], x[start+k+size/2] = u+v, u-v
+				w *= step
+			}
+		}
+	}
+}
+
+// downmixer turns samples at any rate, already mixed to mono, into
+// fingerprintRate by averaging, which also filters out what lies above the
+// new rate's range. It stops accepting samples after
+// maxFingerprintSeconds.
+type downmixer struct {
+	step  float64
+	pos   float64
+	sum   float64
+	count int
+	last  float32
+	out   []float32
+}
+
+func newDownmixer(rate int) *downmixer {
+	return &downmixer{step: float64(rate) / fingerprintRate}
+}
+
+// add takes one sample and reports whether more are wanted.
+func (d *downmixer) add(v float64) bool {
+	if d.step <= 0 {
+		return false
+	}
+	d.sum += v
+	d.count++
+	d.pos++
+	for d.pos >= d.step {
+		if d.count > 0 {
+			d.last = float32(d.sum / float64(d.count))
+			d.sum, d.count = 0, 0
+		}
+		d.out = append(d.out, d.last)
+		d.pos -= d.step
+	}
+	return len(d.out) < maxFingerprintSeconds*fingerprintRate
+}
@@ -0,0 +1,134 @@
+package hasher
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// Two tunes of sixteen half-second notes, in Hz.
+var (
+	tuneA = []float64{220, 247, 262, 294, 330, 349, 392, 440, 392, 349, 330, 294, 262, 247, 220, 262}
+	tuneB = []float64{262, 330, 392, 262, 294, 349, 440, 294, 330, 392, 494, 330, 220, 262, 330, 220}
+)
+
+// tune plays notes with a few overtones as 16-bit samples at rate, after
+// silence seconds of digital silence.
+func tune(notes []float64, rate int, silence float64) []int16 {
+	lead := int(float64(rate) * silence)
+	per := rate / 2
+	samples := make([]int16, lead+per*len(notes))
+	for n, freq := range notes {
+		for i := range per {
+			t := float64(n*per+i) / float64(rate)
+			var v float64
+			for h := 1.0; h <= 5; h++ {
+				v += math.Sin(2*math.Pi*freq*h*t) / h
+			}
+			v += 0.6 * math.Sin(2*math.Pi*freq*1.5*t)
+			samples[lead+n*per+i] = int16(v * 8000)
+		}
+	}
+	return samples
+}
+
+// wavFile builds a mono 16-bit PCM WAVE file.
+func wavFile(rate int, samples []int16) []byte {
+	data := []byte("RIFF")
+	data = binary.LittleEndian.AppendUint32(data, uint32(36+2*len(samples)))
+	data = append(data, "WAVEfmt "...)
+	data = binary.LittleEndian.AppendUint32(data, 16)
+	data = binary.LittleEndian.AppendUint16(data, wavePCM)
+	data = binary.LittleEndian.AppendUint16(data, 1)
+	data = binary.LittleEndian.AppendUint32(data, uint32(rate))
+	data = binary.LittleEndian.AppendUint32(data, uint32(2*rate))
+	data = binary.LittleEndian.AppendUint16(data, 2)
+	data = binary.LittleEndian.AppendUint16(data, 16)
+	data = append(data, "data"...)
+	data = binary.LittleEndian.AppendUint32(data, uint32(2*len(samples)))
+	for _, v := range samples {
+		data = binary.LittleEndian.AppendUint16(data, uint16(v))
+	}
+	return data
+}
+
+// monoFLAC encodes 16-bit mono samples as a FLAC file.
+func monoFLAC(rate int, samples []int16) []byte {
+	var e flacEncoder
+	for start := 0; start < len(samples); start += 4096 {
+		block := make([]int32, min(4096, len(samples)-start))
+		for i := range block {
+			block[i] = int32(samples[start+i])
+		}
+		e.frame([][]int32{block}, 0, subFixed)
+	}
+
+	info := streamInfoBlock(rate, 1, 16, nil)
+	data := []byte("fLaC")
+	data = append(data, 0x80, 0, 0, byte(len(info)))
+	data = append(data, info...)
+	return append(data, e.w.buf...)
+}
+
+func TestFingerprintMatchesResampledAudio(t *testing.T) {
+	const threshold = 10 // the default --audio-threshold
+	wavs := hashFiles(t, ".wav", Options{Fingerprint: true},
+		wavFile(44100, tune(tuneA, 44100, 0)),
+		wavFile(22050, tune(tuneA, 22050, 0)),
+		wavFile(44100, tune(tuneB, 44100, 0)),
+	)
+	flac := hashFiles(t, ".flac", Options{Fingerprint: true}, monoFLAC(48000, tune(tuneA, 48000, 0)))
+	if len(wavs) != 3 || len(flac) != 1 {
+		t.Fatalf("hashed %d and %d files, want 3 and 1", len(wavs), len(flac))
+	}
+
+	same := []HashedFile{wavs[1], flac[0]}
+	for _, h := range append(same, wavs...) {
+		if !h.IsAudio || h.Fingerprint == nil {
+			t.Fatalf("%s was not fingerprinted", h.FileInfo.Path)
+		}
+	}
+	for _, h := range same {
+		distance, ok := FingerprintDistance(wavs[0].Fingerprint, h.Fingerprint)
+		if !ok || distance > threshold {
+			t.Errorf("%s differs by %.1f%% from the same tune at 44.1 kHz", h.FileInfo.Path, distance)
+		}
+	}
+	if distance, ok := FingerprintDistance(wavs[0].Fingerprint, wavs[2].Fingerprint); ok && distance <= threshold {
+		t.Errorf("a different tune differs by only %.1f%%", distance)
+	}
+}
+
+func TestFingerprintSilence(t *testing.T) {
+	const threshold = 10
+	silence := make([]int16, 22050*30)
+	hashed := hashFiles(t, ".wav", Options{Fingerprint: true},
+		wavFile(22050, tune(tuneA, 22050, 60)),
+		wavFile(22050, tune(tuneB, 22050, 60)),
+		wavFile(22050, silence),
+		wavFile(22050, silence),
+	)
+	if len(hashed) != 4 {
+		t.Fatalf("hashed %d of 4 files", len(hashed))
+	}
+
+	// Different tunes after a long silent intro must not match on the
+	// silence they share.
+	a, b := hashed[0], hashed[1]
+	if a.Fingerprint == nil || b.Fingerprint == nil {
+		t.Fatal("tunes after a silent intro were not fingerprinted")
+	}
+	if distance, ok := FingerprintDistance(a.Fingerprint, b.Fingerprint); ok && distance <= threshold {
+		t.Errorf("different tunes after the same silence differ by only %.1f%%", distance)
+	}
+	if distance, ok := FingerprintDistance(a.Fingerprint, a.Fingerprint); !ok || distance != 0 {
+		t.Errorf("a tune after silence differs from itself by %.1f%%", distance)
+	}
+
+	// Silence alone is not fingerprinted, so it is compared byte for byte.
+	for _, h := range hashed[2:] {
+		if h.Fingerprint != nil || h.Hash == "" {
+			t.Errorf("silence has a fingerprint of %d frames and hash %q", len(h.Fingerprint), h.Hash)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package hasher
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+)
+
+// streamInfo holds the STREAMINFO fields needed to decode a FLAC stream.
+type streamInfo struct {
+	SampleRate    int
+	Channels      int
+	BitsPerSample int
+	MD5           []byte
+}
+
+func parseStreamInfo(block []byte) streamInfo {
+	return streamInfo{
+		SampleRate:    int(block[10])<<12 | int(block[11])<<4 | int(block[12])>>4,
+		Channels:      int(block[12]>>1&7) + 1,
+		BitsPerSample: (int(block[12]&1)<<4 | int(block[13])>>4) + 1,
+		MD5:           block[18:34],
+	}
+}
+
+// fingerprintFLAC decodes the FLAC frames in [start, end) and sets
+// h.Fingerprint from them. It returns how many bytes it read through a job
+// reader; decoding stops once maxFingerprintSeconds have been heard.
+func fingerprintFLAC(ctx context.Context, h *HashedFile, file *os.File, start, end int64, info streamInfo, opts Options) (int64, error) {
+	section := io.NewSectionReader(file, start, end-start)
+	dec := flacDecoder{bits: bitReader{r: bufio.NewReaderSize(newJobReader(ctx, section, opts), readBufferSize)}, info: info}
+	mix := newDownmixer(info.SampleRate)
+
+	for full := false; !full; {
+		channels, bps, err := dec.frame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return 0, ctxErr
+			}
+			return 0, errBadAudio
+		}
+
+		scale := 1 / float64(int64(1)<<(bps-1)) / float64(len(channels))
+		for i := range channels[0] {
+			var sum int64
+			for _, samples := range channels {
+				sum += int64(samples[i])
+			}
+			if !mix.add(float64(sum) * scale) {
+				full = true
+				break
+			}
+		}
+	}
+
+	read, _ := section.Seek(0, io.SeekCurrent)
+	return read, h.setFingerprint(mix.out)
+}
+
+var errBadFrame = errors.New("invalid FLAC frame")
+
+// flacDecoder decodes the frames of a FLAC stream, following the format
+// specification (RFC 9639). CRCs are not checked.
+type flacDecoder struct {
+	bits bitReader
+	info streamInfo
+}
+
+// frame decodes the next frame and returns its samples, one slice per
+// channel, and their bit depth. It returns io.EOF at the end of the stream.
+func (d *flacDecoder) frame() ([][]int32, int, error) {
+	b := &d.bits
+	if _, err := b.r.Peek(1); err != nil {
+		return nil, 0, io.EOF
+	}
+
+	if b.read(14) != 0x3FFE {
+		return nil, 0, b.fail(errBadFrame)
+	}
+	b.read(2) // reserved bit and blocking strategy
+	blockCode, rateCode := b.read(4), b.read(4)
+	channelCode, depthCode := b.read(4), b.read(3)
+	b.read(1)
+
+	// The frame or sample number, UTF-8 coded.
+	first := b.read(8)
+	for mask := uint64(0x40); first&0x80 != 0 && first&mask != 0; mask >>= 1 {
+		b.read(8)
+	}
+
+	var blockSize int
+	switch {
+	case blockCode == 1:
+		blockSize = 192
+	case blockCode >= 2 && blockCode <= 5:
+		blockSize = 576 << (blockCode - 2)
+	case blockCode == 6:
+		blockSize = int(b.read(8)) + 1
+	case blockCode == 7:
+		blockSize = int(b.read(16)) + 1
+	case blockCode >= 8:
+		blockSize = 256 << (blockCode - 8)
+	default:
+		return nil, 0, b.fail(errBadFrame)
+	}
+	switch rateCode {
+	case 12:
+		b.read(8)
+	case 13, 14:
+		b.read(16)
+	case 15:
+		return nil, 0, b.fail(errBadFrame)
+	}
+
+	bps := d.info.BitsPerSample
+	if depthCode != 0 {
+		bps = [...]int{0, 8, 12, 0, 16, 20, 24, 32}[depthCode]
+		if bps == 0 {
+			return nil, 0, b.fail(errBadFrame)
+		}
+	}
+	b.read(8) // CRC-8
+
+	count := int(channelCode) + 1
+	if channelCode >= 8 {
+		if channelCode > 10 {
+			return nil, 0, b.fail(errBadFrame)
+		}
+		count = 2
+	}
+	channels := make([][]int32, count)
+	for ch := range channels {
+		depth := bps
+		// The side channel needs one more bit.
+		if channelCode == 8 && ch == 1 || channelCode == 9 && ch == 0 || channelCode == 10 && ch == 1 {
+			depth++
+		}
+		channels[ch] = make([]int32, blockSize)
+		if err := d.subframe(channels[ch], depth); err != nil {
+			return nil, 0, b.fail(err)
+		}
+	}
+
+	left, right := channels[0], channels[len(channels)-1]
+	for i := range blockSize {
+		switch channelCode {
+		case 8:
+			right[i] = left[i] - right[i]
+		case 9:
+			left[i] += right[i]
+		case 10:
+			mid, side := left[i]<<1|right[i]&1, right[i]
+			left[i], right[i] = (mid+side)>>1, (mid-side)>>1
+		}
+	}
+
+	b.align()
+	b.read(16) // CRC-16
+	return channels, bps, b.err
+}
+
+// fixedCoefficients are the predictors of the fixed subframe orders.
+var fixedCoefficients = [][]int64{{}, {1}, {2, -1}, {3, -3, 1}, {4, -6, 4, -1}}
+
+func (d *flacDecoder) subframe(samples []int32, bps int) error {
+	b := &d.bits
+	b.read(1)
+	kind := b.read(6)
+	wasted := 0
+	if b.read(1) == 1 {
+		wasted = int(b.unary()) + 1
+		bps -= wasted
+	}
+	if bps <= 0 {
+		return errBadFrame
+	}
+
+	switch {
+	case kind == 0:
+		v := int32(b.signed(uint(bps)))
+		for i := range samples {
+			samples[i] = v
+		}
+	case kind == 1:
+		for i := range samples {
+			samples[i] = int32(b.signed(uint(bps)))
+		}
+	case kind >= 8 && kind <= 12 || kind >= 32:
+		order := int(kind - 8)
+		if kind >= 32 {
+			order = int(kind - 31)
+		}
+		if order > len(samples) {
+			return errBadFrame
+		}
+		for i := range order {
+			samples[i] = int32(b.signed(uint(bps)))
+		}
+
+		var coefficients []int64
+		var shift int64
+		if kind < 32 {
+			coefficients = fixedCoefficients[order]
+		} else {
+			precision := b.read(4) + 1
+			if precision == 16 {
+				return errBadFrame
+			}
+			if shift = b.signed(5); shift < 0 {
+				return errBadFrame
+			}
+			coefficients = make([]int64, order)
+			for i := range coefficients {
+				coefficients[i] = b.signed(uint(precision))
+			}
+		}
+		if err := d.residual(samples, order); err != nil {
+			return err
+		}
+		predict(samples, coefficients, uint(shift))
+	default:
+		return errBadFrame
+	}
+
+	if wasted > 0 {
+		for i := range samples {
+			samples[i] <<= wasted
+		}
+	}
+	return b.err
+}
+
+// predict adds the prediction from the preceding samples to the residuals
+// stored after the warm-up samples.
+func predict(samples []int32, coefficients []int64, shift uint) {
+	order := len(coefficients)
+	for i := order; i < len(samples); i++ {
+		var sum int64
+		for j, c := range coefficients {
+			sum += c * int64(samples[i-1-j])
+		}
+		samples[i] += int32(sum >> shift)
+	}
+}
+
+// residual reads the Rice-coded residual of a subframe into samples after
+// the warm-up samples.
+func (d *flacDecoder) residual(samples []int32, order int) error {
+	b := &d.bits
+	paramBits, escape := uint(4), uint64(15)
+	switch b.read(2) {
+	case 0:
+	case 1:
+		paramBits, escape = 5, 31
+	default:
+		return errBadFrame
+	}
+
+	// Some encoders chose partition orders that do not divide the block
+	// size; the samples left over keep a zero residual.
+	partitions := 1 << b.read(4)
+	size := len(samples) / partitions
+	if size < order {
+		return errBadFrame
+	}
+	i := order
+	for p := range partitions {
+		n := size
+		if p == 0 {
+			n -= order
+		}
+		param := b.read(paramBits)
+		if param == escape {
+			width := uint(b.read(5))
+			for range n {
+				samples[i] = int32(b.signed(width))
+				i++
+			}
+			continue
+		}
+		for range n {
+			v := b.unary()<<param | b.read(uint(param))
+			samples[i] = int32(v>>1) ^ -int32(v&1)
+			i++
+		}
+		if b.err != nil {
+			return b.err
+		}
+	}
+	return nil
+}
+
+// bitReader reads big-endian bit fields. After an error it returns zeros,
+// and the error is kept in err.
+type bitReader struct {
+	r     *bufio.Reader
+	cache uint64
+	n     uint
+	err   error
+}
+
+// read returns the next n bits, for n up to 32.
+func (b *bitReader) read(n uint) uint64 {
+	for b.n < n {
+		c, err := b.r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			b.fail(err)
+			return 0
+		}
+		b.cache = b.cache<<8 | uint64(c)
+		b.n += 8
+	}
+	b.n -= n
+	return b.cache >> b.n & (1<<n - 1)
+}
+
+// signed reads an n-bit two's complement number.
+func (b *bitReader) signed(n uint) int64 {
+	if n == 0 {
+		return 0
+	}
+	return int64(b.read(n)<<(64-n)) >> (64 - n)
+}
+
+// unary counts zero bits up to the next one bit.
+func (b *bitReader) unary() uint64 {
+	var count uint64
+	for b.read(1) == 0 && b.err == nil {
+		count++
+	}
+	return count
+}
+
+// align skips to the next byte boundary.
+func (b *bitReader) align() {
+	b.n -= b.n % 8
+}
+
+func (b *bitReader) fail(err error) error {
+	if b.err == nil {
+		b.err = err
+	}
+	return b.err
+}
@@ -0,0 +1,254 @@
+package hasher
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"slices"
+	"testing"
+)
+
+// streamInfoBlock builds a STREAMINFO block.
+func streamInfoBlock(rate, channels, bps int, md5 []byte) []byte {
+	info := make([]byte, 34)
+	binary.BigEndian.PutUint64(info[10:], uint64(rate)<<44|uint64(channels-1)<<41|uint64(bps-1)<<36)
+	copy(info[18:], md5)
+	return info
+}
+
+// bitWriter writes big-endian bit fields, the inverse of bitReader.
+type bitWriter struct {
+	buf  []byte
+	cur  byte
+	bits uint
+}
+
+func (w *bitWriter) write(v uint64, n uint) {
+	for i := n; i > 0; i-- {
+		w.cur = w.cur<<1 | byte(v>>(i-1)&1)
+		w.bits++
+		if w.bits == 8 {
+			w.buf = append(w.buf, w.cur)
+			w.cur, w.bits = 0, 0
+		}
+	}
+}
+
+func (w *bitWriter) signed(v int64, n uint) {
+	w.write(uint64(v)&(1<<n-1), n)
+}
+
+func (w *bitWriter) align() {
+	for w.bits != 0 {
+		w.write(0, 1)
+	}
+}
+
+// Subframe kinds the test encoder writes.
+const (
+	subConstant = iota
+	subVerbatim
+	subFixed
+	subLPC
+)
+
+// flacEncoder writes FLAC frames of 16-bit samples for the tests, with
+// zero CRCs, which the decoder does not check.
+type flacEncoder struct {
+	w      bitWriter
+	frames int
+}
+
+// frame writes one frame. channelCode selects independent channels (0 for
+// mono, 1 for stereo) or one of the stereo decorrelations 8 to 10.
+func (e *flacEncoder) frame(channels [][]int32, channelCode uint64, kind int) {
+	w := &e.w
+	size := len(channels[0])
+	w.write(0x3FFE, 14)
+	w.write(0, 2)
+	blockCode := uint64(7)
+	if size == 4096 {
+		blockCode = 12
+	}
+	w.write(blockCode, 4)
+	w.write(0, 4) // sample rate from STREAMINFO
+	w.write(channelCode, 4)
+	w.write(4, 3) // 16 bits per sample
+	w.write(0, 1)
+	w.write(uint64(e.frames), 8)
+	e.frames++
+	if blockCode == 7 {
+		w.write(uint64(size-1), 16)
+	}
+	w.write(0, 8) // CRC-8
+
+	subframes, depths := channels, []uint{16, 16}
+	if len(channels) == 2 && channelCode >= 8 {
+		left, right := channels[0], channels[1]
+		a, b := make([]int32, size), make([]int32, size)
+		for i := range size {
+			switch channelCode {
+			case 8:
+				a[i], b[i] = left[i], left[i]-right[i]
+			case 9:
+				a[i], b[i] = left[i]-right[i], right[i]
+			case 10:
+				a[i], b[i] = (left[i]+right[i])>>1, left[i]-right[i]
+			}
+		}
+		subframes = [][]int32{a, b}
+		depths = map[uint64][]uint{8: {16, 17}, 9: {17, 16}, 10: {16, 17}}[channelCode]
+	}
+	for ch, samples := range subframes {
+		e.subframe(samples, depths[ch], kind)
+	}
+	w.align()
+	w.write(0, 16) // CRC-16
+}
+
+func (e *flacEncoder) subframe(samples []int32, bps uint, kind int) {
+	w := &e.w
+	w.write(0, 1)
+	switch kind {
+	case subConstant:
+		w.write(0, 6)
+		w.write(0, 1)
+		w.signed(int64(samples[0]), bps)
+	case subVerbatim:
+		w.write(1, 6)
+		w.write(0, 1)
+		for _, v := range samples {
+			w.signed(int64(v), bps)
+		}
+	case subFixed, subLPC:
+		// Order 2 predicts 2*s[i-1] - s[i-2]; the LPC subframe codes the
+		// same predictor as (4*s[i-1] - 2*s[i-2]) >> 1.
+		if kind == subFixed {
+			w.write(8+2, 6)
+		} else {
+			w.write(32+2-1, 6)
+		}
+		w.write(0, 1)
+		w.signed(int64(samples[0]), bps)
+		w.signed(int64(samples[1]), bps)
+		if kind == subLPC {
+			w.write(15-1, 4) // coefficient precision
+			w.signed(1, 5)   // shift
+			w.signed(4, 15)
+			w.signed(-2, 15)
+		}
+
+		residual := make([]int64, len(samples)-2)
+		var total uint64
+		for i := range residual {
+			residual[i] = int64(samples[i+2]) - (2*int64(samples[i+1]) - int64(samples[i]))
+			total += uint64(max(residual[i], -residual[i]))
+		}
+		param := uint(0)
+		for param < 14 && uint64(len(residual))<<(param+1) < total {
+			param++
+		}
+		w.write(0, 2) // 4-bit Rice parameters
+		w.write(0, 4) // one partition
+		w.write(uint64(param), 4)
+		for _, r := range residual {
+			u := uint64(r<<1 ^ r>>63)
+			for range u >> param {
+				w.write(0, 1)
+			}
+			w.write(1, 1)
+			w.write(u, param)
+		}
+	}
+}
+
+// testSignal returns a second of a 16-bit stereo signal at 44.1 kHz: two
+// chords, one per channel, with a little noise.
+func testSignal() [][]int32 {
+	left, right := make([]int32, 44100), make([]int32, 44100)
+	noise := uint32(1)
+	for i := range left {
+		noise = noise*1664525 + 1013904223
+		t := float64(i) / 44100
+		l := 9000*math.Sin(2*math.Pi*220*t) + 4000*math.Sin(2*math.Pi*277*t)
+		r := 7000*math.Sin(2*math.Pi*330*t) + 5000*math.Sin(2*math.Pi*415*t)
+		left[i] = int32(l) + int32(noise>>24) - 128
+		right[i] = int32(r) - int32(noise>>25) + 64
+	}
+	// A stretch of digital silence for the constant subframes.
+	for i := 8192; i < 12288; i++ {
+		left[i], right[i] = 0, 0
+	}
+	return [][]int32{left, right}
+}
+
+func TestFLACDecoderRoundTrip(t *testing.T) {
+	signal := testSignal()
+	channelCodes := []uint64{1, 8, 9, 10}
+	kinds := []int{subVerbatim, subFixed, subLPC}
+
+	var e flacEncoder
+	var sizes []int
+	for start, n := 0, 0; start < len(signal[0]); n++ {
+		size := min(4096, len(signal[0])-start)
+		block := [][]int32{signal[0][start : start+size], signal[1][start : start+size]}
+		kind := kinds[n%len(kinds)]
+		if start == 8192 {
+			kind = subConstant
+		}
+		e.frame(block, channelCodes[n%len(channelCodes)], kind)
+		sizes = append(sizes, size)
+		start += size
+	}
+	// A mono frame as well.
+	e.frame([][]int32{signal[0][:1000]}, 0, subFixed)
+	sizes = append(sizes, 1000)
+
+	dec := flacDecoder{
+		bits: bitReader{r: bufio.NewReader(bytes.NewReader(e.w.buf))},
+		info: parseStreamInfo(streamInfoBlock(44100, 2, 16, nil)),
+	}
+	var left, right []int32
+	for i, size := range sizes {
+		channels, bps, err := dec.frame()
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if bps != 16 || len(channels[0]) != size {
+			t.Fatalf("frame %d: got %d samples of %d bits, want %d of 16", i, len(channels[0]), bps, size)
+		}
+		if i == len(sizes)-1 {
+			if len(channels) != 1 || !slices.Equal(channels[0], signal[0][:1000]) {
+				t.Error("mono frame decoded wrong")
+			}
+			continue
+		}
+		left = append(left, channels[0]...)
+		right = append(right, channels[1]...)
+	}
+	if !slices.Equal(left, signal[0]) || !slices.Equal(right, signal[1]) {
+		t.Error("decoded samples differ from the encoded ones")
+	}
+	if _, _, err := dec.frame(); err != io.EOF {
+		t.Errorf("got %v after the last frame, want EOF", err)
+	}
+}
+
+func TestFLACDecoderInvalid(t *testing.T) {
+	var e flacEncoder
+	e.frame([][]int32{make([]int32, 100)}, 0, subVerbatim)
+	data := e.w.buf
+
+	tests := map[string][]byte{
+		"bad sync":  append([]byte{0x00}, data[1:]...),
+		"truncated": data[:len(data)/2],
+	}
+	for name, data := range tests {
+		dec := flacDecoder{bits: bitReader{r: bufio.NewReader(bytes.NewReader(data))}}
+		if _, _, err := dec.frame(); err == nil || err == io.EOF {
+			t.Errorf("%s: got %v, want an error", name, err)
+		}
+	}
+}
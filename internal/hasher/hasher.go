@@ -1,87 +1,241 @@
 package hasher
 
 import (
-	"crypto/sha256"
+	"context"
 	"doppel/internal/scanner"
+	"doppel/internal/throttle"
 	"encoding/hex"
 	"io"
 	"os"
-
-	"github.com/corona10/goimagehash"
+	"slices"
+	"strings"
 )
 
 type HashedFile struct {
-	FileInfo   scanner.FileInfo
-	Hash       string
-	PHash      *goimagehash.ImageHash
+	FileInfo scanner.FileInfo
+	Hash     string
+	// MIME is the type detected from the start of the file, when it was
+	// read.
+	MIME string
+	// PHash holds one perceptual hash per algorithm in Options.PHash, in
+	// the same order.
+	PHash []ImageHash
+	// Orientations holds the perceptual hashes of the image in each
+	// orientation, indexed by Transform, when Options.Invariant is set.
+	Orientations [][]ImageHash
+	// Image describes the decoded image, when it was perceptually hashed.
+	Image ImageInfo
+	// Audio hashes the audio of an MP3 or FLAC file without its tags, when
+	// Options.Audio is set.
+	Audio string
+	// Fingerprint is the acoustic fingerprint of a WAV or FLAC file, when
+	// Options.Fingerprint is set.
+	Fingerprint Fingerprint
+	// Tags holds the metadata of an audio file with either of them.
+	Tags Tags
+	// Text hashes the canonical form of a text file, when
+	// Options.TextNormalize is set.
+	Text       string
 	IsImage    bool
+	IsAudio    bool
+	IsText     bool
 	Similarity int
 }
 
-func HashFiles(files []scanner.FileInfo, exact bool) []HashedFile {
-	var images, nonImages []scanner.FileInfo
-	for _, file := range files {
-		if !exact && isImage(file.Path) {
-			images = append(images, file)
-		} else {
-			nonImages = append(nonImages, file)
-		}
+type Options struct {
+	// Exact disables perceptual hashing, so images only match byte for byte.
+	Exact bool
+	// Hasher is the content hash algorithm. Nil means SHA256.
+	Hasher Hasher
+	// PHash lists the perceptual hash algorithms for images. Images only
+	// match when all of them agree. Empty means DifferenceHash.
+	PHash []PHashAlgorithm
+	// Classify decides which files are treated as images, audio or text.
+	// Empty means ClassifyExtension.
+	Classify Classify
+	// Audio matches MP3 and FLAC files by their audio alone, whatever
+	// tags they carry.
+	Audio bool
+	// Fingerprint decodes WAV and FLAC files and matches them by an
+	// acoustic fingerprint, so re-encoded copies match too.
+	Fingerprint bool
+	// TextNormalize matches text files by a canonical form, so they match
+	// whatever their line endings, trailing whitespace, byte order mark or
+	// encoding. CollapseBlankLines also ignores how many blank lines
+	// separate two lines.
+	TextNormalize      bool
+	CollapseBlankLines bool
+	// Invariant hashes images in all eight orientations, so rotated and
+	// mirrored copies match too.
+	Invariant bool
+	// Workers bounds the number of files hashed at once. Zero means one per
+	// CPU.
+	Workers int
+	// Progress, if set, is told how many bytes will be read and how many
+	// have been.
+	Progress Progress
+	// Cache, if set, supplies hashes from an earlier run and receives the
+	// ones computed in this one.
+	Cache Cache
+	// Throttle, if set, limits the read rate of every job together.
+	Throttle *throttle.Throttle
+	// OnError, if set, is told about files that could not be hashed and
+	// are left out, and about images that could not be decoded and are
+	// compared byte for byte instead. It is called from several goroutines
+	// at once.
+	OnError func(path string, err error)
+}
+
+func (o Options) reportError(path string, err error) {
+	if o.OnError != nil {
+		o.OnError(path, err)
 	}
+}
 
-	var hashed []HashedFile
+// Cache holds hashes across runs. Lookup must only return a result when the
+// file is unchanged since it was hashed, and must pass it through
+// Options.Compatible. Both methods are called from several goroutines at
+// once.
+type Cache interface {
+	Lookup(file scanner.FileInfo, opts Options) (HashedFile, bool)
+	Store(h HashedFile)
+}
 
-	for _, file := range images {
-		if phash, err := perceptualHashImage(file.Path); err == nil {
-			hashed = append(hashed, HashedFile{
-				FileInfo: file,
-				PHash:    phash,
-				IsImage:  true,
-			})
-		}
+// Compatible drops the hashes of h that were made with other algorithms
+// than the ones opts selects, so they are never compared with new ones.
+func (o Options) Compatible(h HashedFile) HashedFile {
+	if !MadeWith(h.Hash, o.hasher()) {
+		h.Hash = ""
 	}
 
-	sizeGroups := groupBySize(nonImages)
-	for _, group := range sizeGroups {
-		if len(group) < 2 {
-			continue
+	want := o.phashAlgorithms()
+	usable := sameAlgorithms(h.PHash, want)
+	if o.Invariant {
+		usable = usable && len(h.Orientations) == len(transformNames)
+		for _, hashes := range h.Orientations {
+			usable = usable && sameAlgorithms(hashes, want)
 		}
+	} else {
+		h.Orientations = nil
+	}
+	if !usable {
+		h.PHash, h.Orientations = nil, nil
+	}
+	h.IsImage = h.PHash != nil
 
-		for _, file := range group {
-			if hash, err := hashFile(file.Path); err == nil {
-				hashed = append(hashed, HashedFile{
-					FileInfo: file,
-					Hash:     hash,
-					IsImage:  false,
-				})
-			}
-		}
+	// With Options.Fingerprint, a FLAC file needs its fingerprint even if
+	// its audio hash is known.
+	switch {
+	case o.Fingerprint && fingerprintTypes[h.MIME]:
+		h.Audio = ""
+	case o.Audio && (MadeWith(h.Audio, o.hasher()) || strings.HasPrefix(h.Audio, flacMD5Prefix)):
+		h.Fingerprint = nil
+	default:
+		h.Audio, h.Fingerprint = "", nil
+	}
+	if h.Audio == "" && h.Fingerprint == nil {
+		h.Tags = nil
 	}
+	h.IsAudio = h.Audio != "" || h.Fingerprint != nil
 
-	return hashed
+	text, ok := h.Text, !strings.HasPrefix(h.Text, collapsedPrefix)
+	if o.CollapseBlankLines {
+		text, ok = strings.CutPrefix(h.Text, collapsedPrefix)
+	}
+	if !o.TextNormalize || !ok || !MadeWith(text, o.hasher()) {
+		h.Text = ""
+	}
+	h.IsText = h.Text != ""
+	return h
 }
 
-func groupBySize(files []scanner.FileInfo) map[int64][]scanner.FileInfo {
-	groups := make(map[int64][]scanner.FileInfo)
-
-	for _, file := range files {
-		groups[file.Size] = append(groups[file.Size], file)
+func sameAlgorithms(hashes []ImageHash, want []PHashAlgorithm) bool {
+	if len(hashes) != len(want) {
+		return false
+	}
+	for i := range hashes {
+		if hashes[i].Algorithm != want[i] {
+			return false
+		}
 	}
+	return true
+}
 
-	return groups
+// Progress receives hashing progress. It is called from several goroutines
+// at once.
+type Progress interface {
+	// Queued adds bytes that will be read for a new hashing job.
+	Queued(bytes int64)
+	// Start records the file a worker is now reading.
+	Start(path string)
+	// Read counts bytes read.
+	Read(n int64)
 }
 
-func hashFile(path string) (string, error) {
+// HashFiles hashes a complete list of files. See HashStream for how files
+// are selected for content and perceptual hashing. If ctx is cancelled, the
+// files hashed so far are returned.
+func HashFiles(ctx context.Context, files []scanner.FileInfo, opts Options) []HashedFile {
+	var hashed []HashedFile
+	for batch := range HashStream(ctx, slices.Values(files), opts) {
+		hashed = append(hashed, batch.Files...)
+	}
+	return hashed
+}
+
+// readBufferSize is large so that few read operations are needed per file,
+// which matters when they are limited with --max-iops.
+const readBufferSize = 256 << 10
+
+// hashFile returns the content hash of a file and its detected MIME type.
+func hashFile(ctx context.Context, path string, opts Options) (string, string, error) {
 	// #nosec G304 - path comes from filesystem scan, not user input
 	file, err := os.Open(path)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 	defer file.Close()
 
-	hasher := sha256.New()
-	if _, err := io.Copy(hasher, file); err != nil {
-		return "", err
+	algorithm := opts.hasher()
+	hasher := algorithm.New()
+	var head headBuffer
+	buf := make([]byte, readBufferSize)
+	if _, err := io.CopyBuffer(io.MultiWriter(hasher, &head), newJobReader(ctx, file, opts), buf); err != nil {
+		return "", "", err
+	}
+
+	hash := algorithm.Name() + ":" + hex.EncodeToString(hasher.Sum(nil))
+	return hash, detectType(head.data), nil
+}
+
+// jobReader stops a hashing job as soon as ctx is cancelled, so an
+// interrupt does not wait for a large file to be read to the end. It also
+// applies the read limits and reports the bytes it reads.
+type jobReader struct {
+	ctx  context.Context
+	r    io.Reader
+	opts Options
+}
+
+func newJobReader(ctx context.Context, r io.Reader, opts Options) io.Reader {
+	return &jobReader{ctx: ctx, r: r, opts: opts}
+}
+
+func (r *jobReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if err := r.opts.Throttle.BeforeRead(r.ctx); err != nil {
+		return 0, err
+	}
+
+	n, err := r.r.Read(p)
+	if r.opts.Progress != nil {
+		r.opts.Progress.Read(int64(n))
 	}
 
-	return hex.EncodeToString(hasher.Sum(nil)), nil
+	if throttleErr := r.opts.Throttle.AfterRead(r.ctx, n); throttleErr != nil && err == nil {
+		err = throttleErr
+	}
+	return n, err
 }
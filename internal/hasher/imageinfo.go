@@ -0,0 +1,76 @@
+package hasher
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"math/bits"
+)
+
+// ImageInfo describes a decoded image. Zero fields are unknown.
+type ImageInfo struct {
+	// Width and Height are the dimensions as displayed, after any EXIF
+	// orientation is applied.
+	Width, Height int
+	// Format is the name of the decoder, such as "jpeg" or "png".
+	Format string
+	// BitDepth is the number of bits stored per pixel.
+	BitDepth int
+	// Quality is the estimated JPEG quality, from 1 to 100.
+	Quality int
+}
+
+// Pixels returns the number of pixels in the image.
+func (i ImageInfo) Pixels() int {
+	return i.Width * i.Height
+}
+
+// String describes the image, e.g. "4032x3024 jpeg 24-bit q92".
+func (i ImageInfo) String() string {
+	if i.Format == "" {
+		return ""
+	}
+	s := fmt.Sprintf("%dx%d %s", i.Width, i.Height, i.Format)
+	if i.BitDepth > 0 {
+		s += fmt.Sprintf(" %d-bit", i.BitDepth)
+	}
+	if i.Quality > 0 {
+		s += fmt.Sprintf(" q%d", i.Quality)
+	}
+	return s
+}
+
+// pngChannels is the number of samples per pixel of each PNG color type.
+var pngChannels = map[byte]int{0: 1, 2: 3, 3: 1, 4: 2, 6: 4}
+
+// headerBitDepth returns the bits per pixel stated in the header of a PNG
+// or BMP file, whose decoders widen pixels, or 0 for other files.
+func headerBitDepth(header []byte) int {
+	switch {
+	case len(header) >= 26 && string(header[1:4]) == "PNG" && string(header[12:16]) == "IHDR":
+		return int(header[24]) * pngChannels[header[25]]
+	case len(header) >= 30 && string(header[:2]) == "BM":
+		return int(binary.LittleEndian.Uint16(header[28:]))
+	}
+	return 0
+}
+
+// bitDepth returns the bits per pixel that follow from the decoded pixel
+// type.
+func bitDepth(img image.Image) int {
+	switch img := img.(type) {
+	case *image.Gray, *image.Alpha:
+		return 8
+	case *image.Gray16, *image.Alpha16:
+		return 16
+	case *image.YCbCr:
+		return 24
+	case *image.RGBA, *image.NRGBA, *image.NYCbCrA, *image.CMYK:
+		return 32
+	case *image.RGBA64, *image.NRGBA64:
+		return 64
+	case *image.Paletted:
+		return max(1, bits.Len(uint(len(img.Palette)-1)))
+	}
+	return 0
+}
@@ -0,0 +1,125 @@
+package hasher
+
+import (
+	"encoding/binary"
+	"iter"
+	"math"
+)
+
+// jpegSegments yields the marker and payload of each segment of a JPEG
+// file before the image data starts. It stops at the first segment that
+// does not fit in data, so only complete segments are seen.
+func jpegSegments(data []byte) iter.Seq2[byte, []byte] {
+	return func(yield func(byte, []byte) bool) {
+		if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+			return
+		}
+
+		for pos := 2; pos+4 <= len(data); {
+			if data[pos] != 0xFF {
+				return
+			}
+			marker := data[pos+1]
+			switch {
+			case marker == 0xFF:
+				// Fill byte before a marker.
+				pos++
+				continue
+			case marker == 0x01 || marker >= 0xD0 && marker <= 0xD7:
+				// Markers without a segment.
+				pos += 2
+				continue
+			case marker == 0xDA || marker == 0xD9:
+				// Image data starts; metadata must come before it.
+				return
+			}
+
+			length := int(binary.BigEndian.Uint16(data[pos+2:]))
+			end := pos + 2 + length
+			if length < 2 || end > len(data) {
+				return
+			}
+			if !yield(marker, data[pos+4:end]) {
+				return
+			}
+			pos = end
+		}
+	}
+}
+
+// unzig maps the zig-zag order quantization tables are stored in to the
+// natural order of the 8x8 block.
+var unzig = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// standardLuminance is the luminance quantization table of the JPEG
+// standard (Annex K), in natural order, which libjpeg scales by quality.
+var standardLuminance = [64]float64{
+	16, 11, 10, 16, 24, 40, 51, 61,
+	12, 12, 14, 19, 26, 58, 60, 55,
+	14, 13, 16, 24, 40, 57, 69, 56,
+	14, 17, 22, 29, 51, 87, 80, 62,
+	18, 22, 37, 56, 68, 109, 103, 77,
+	24, 35, 55, 64, 81, 104, 113, 92,
+	49, 64, 78, 87, 103, 121, 120, 101,
+	72, 92, 95, 98, 112, 100, 103, 99,
+}
+
+// jpegQuality estimates the quality setting a JPEG was saved with, on
+// libjpeg's 1 to 100 scale, from how much its luminance table is scaled
+// from the standard one. Encoders with their own tables get a rough
+// figure. It returns 0 when the table is not found in data.
+func jpegQuality(data []byte) int {
+	for marker, payload := range jpegSegments(data) {
+		if marker != 0xDB {
+			continue
+		}
+		for len(payload) > 0 {
+			precision, id := payload[0]>>4, payload[0]&0x0F
+			size := 64
+			if precision != 0 {
+				size = 128
+			}
+			if len(payload) < 1+size {
+				return 0
+			}
+			table := payload[1 : 1+size]
+			payload = payload[1+size:]
+			if id != 0 {
+				continue
+			}
+
+			var scale float64
+			ones := true
+			for i := range 64 {
+				q := float64(table[i])
+				if precision != 0 {
+					q = float64(binary.BigEndian.Uint16(table[2*i:]))
+				}
+				ones = ones && q == 1
+				scale += q * 100 / standardLuminance[unzig[i]]
+			}
+			if ones {
+				return 100
+			}
+			scale /= 64
+
+			// libjpeg scales by 200-2q percent above quality 50 and by
+			// 5000/q percent below.
+			quality := 5000 / scale
+			if scale <= 100 {
+				quality = (200 - scale) / 2
+			}
+			return int(math.Max(1, math.Min(100, math.Round(quality))))
+		}
+	}
+	return 0
+}
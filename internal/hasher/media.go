@@ -0,0 +1,106 @@
+package hasher
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// errNotMedia is returned for a file whose content shows it is neither an
+// image, audio nor text that is being matched.
+var errNotMedia = errors.New("not an image, audio or text file")
+
+type mediaKind int
+
+const (
+	noMedia mediaKind = iota
+	imageMedia
+	audioMedia
+	textMedia
+)
+
+// mediaKind decides how a file is matched other than byte for byte: by its
+// detected MIME type when sniffing, by its name otherwise.
+func (o Options) mediaKind(path, mime string, sniff bool) mediaKind {
+	if sniff {
+		switch {
+		case o.Audio && audioTypes[mime], o.Fingerprint && fingerprintTypes[mime]:
+			return audioMedia
+		case !o.Exact && decodableTypes[mime]:
+			return imageMedia
+		case o.TextNormalize && strings.HasPrefix(mime, "text/"):
+			return textMedia
+		}
+		return noMedia
+	}
+
+	switch {
+	case o.Audio && isAudio(path), o.Fingerprint && isFingerprintable(path):
+		return audioMedia
+	case !o.Exact && isImage(path):
+		return imageMedia
+	case o.TextNormalize && isText(path):
+		return textMedia
+	}
+	return noMedia
+}
+
+// hashMedia hashes a file as an image, audio or text, whichever it is.
+// With sniff, that is decided by the file's first bytes, and its bytes are
+// only queued for progress once it turns out to be one of them.
+func hashMedia(ctx context.Context, h *HashedFile, sniff bool, opts Options) error {
+	// #nosec G304 - path comes from filesystem scan, not user input
+	file, err := os.Open(h.FileInfo.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	quiet := opts
+	quiet.Progress = nil
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(newJobReader(ctx, file, quiet), head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	head = head[:n]
+	h.MIME = detectType(head)
+
+	kind := opts.mediaKind(h.FileInfo.Path, h.MIME, sniff)
+	if kind == noMedia {
+		return errNotMedia
+	}
+	progress := opts.Progress
+	if progress != nil && sniff {
+		progress.Queued(h.FileInfo.Size)
+	}
+
+	if kind == audioMedia {
+		read, err := hashAudio(ctx, h, file, opts)
+		// Tags and skipped parts are read without being counted.
+		skipRead(progress, h.FileInfo.Size-read)
+		if err != nil {
+			return err
+		}
+		h.IsAudio = true
+		return nil
+	}
+
+	skipRead(progress, int64(n))
+	content := io.MultiReader(bytes.NewReader(head), newJobReader(ctx, file, opts))
+	if kind == textMedia {
+		if err := hashText(ctx, h, content, opts); err != nil {
+			return err
+		}
+		h.IsText = true
+		return nil
+	}
+	if err := perceptualHashImage(h, content, opts); err != nil {
+		return err
+	}
+	h.IsImage = true
+	return nil
+}
@@ -0,0 +1,167 @@
+package hasher
+
+import (
+	"image"
+	"image/draw"
+
+	"github.com/nfnt/resize"
+)
+
+// Transform is one of the eight ways to rotate or mirror an image without
+// losing pixels (the dihedral group of the square).
+type Transform int
+
+const (
+	Identity Transform = iota
+	Rotate90
+	Rotate180
+	Rotate270
+	FlipHorizontal
+	FlipVertical
+	Transpose
+	Transverse
+)
+
+var transformNames = [...]string{
+	Identity:       "as kept",
+	Rotate90:       "rotated 90° clockwise",
+	Rotate180:      "rotated 180°",
+	Rotate270:      "rotated 90° counter-clockwise",
+	FlipHorizontal: "mirrored left-right",
+	FlipVertical:   "mirrored top-bottom",
+	Transpose:      "transposed",
+	Transverse:     "transversed",
+}
+
+func (t Transform) String() string {
+	return transformNames[t]
+}
+
+// orientSize is the side of the square images are scaled to before being
+// transformed. Every perceptual hash scales far below it anyway, and a
+// square can be rotated without changing shape.
+const orientSize = 256
+
+// orientations returns the image in all eight orientations, indexed by
+// Transform.
+func orientations(img image.Image) [8]image.Image {
+	scaled := resize.Resize(orientSize, orientSize, img, resize.Bilinear)
+	square := image.Rect(0, 0, orientSize, orientSize)
+	src := image.NewRGBA(square)
+	draw.Draw(src, square, scaled, scaled.Bounds().Min, draw.Src)
+	n := orientSize - 1
+
+	var result [8]image.Image
+	for t := range result {
+		dst := image.NewRGBA(square)
+		for y := 0; y <= n; y++ {
+			for x := 0; x <= n; x++ {
+				sx, sy := Transform(t).source(x, y, n, n)
+				copy(dst.Pix[dst.PixOffset(x, y):][:4], src.Pix[src.PixOffset(sx, sy):][:4])
+			}
+		}
+		result[t] = dst
+	}
+	return result
+}
+
+// apply returns img with t applied at full size. Rotating by 90 or 270
+// degrees, transposing and transversing swap width and height.
+func (t Transform) apply(img image.Image) image.Image {
+	if t == Identity {
+		return img
+	}
+
+	bounds := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+	mx, my := bounds.Dx()-1, bounds.Dy()-1
+
+	size := src.Bounds()
+	if t.swapsAxes() {
+		size = image.Rect(0, 0, bounds.Dy(), bounds.Dx())
+	}
+	dst := image.NewRGBA(size)
+	for y := 0; y < size.Dy(); y++ {
+		for x := 0; x < size.Dx(); x++ {
+			sx, sy := t.source(x, y, mx, my)
+			copy(dst.Pix[dst.PixOffset(x, y):][:4], src.Pix[src.PixOffset(sx, sy):][:4])
+		}
+	}
+	return dst
+}
+
+func (t Transform) swapsAxes() bool {
+	return t == Rotate90 || t == Rotate270 || t == Transpose || t == Transverse
+}
+
+// source maps a pixel of the transformed image back to the original, whose
+// last column and row are mx and my.
+func (t Transform) source(x, y, mx, my int) (int, int) {
+	switch t {
+	case Rotate90:
+		return y, my - x
+	case Rotate180:
+		return mx - x, my - y
+	case Rotate270:
+		return mx - y, x
+	case FlipHorizontal:
+		return mx - x, y
+	case FlipVertical:
+		return x, my - y
+	case Transpose:
+		return y, x
+	case Transverse:
+		return mx - y, my - x
+	}
+	return x, y
+}
+
+// Then returns the transform that applies t and then u.
+func (t Transform) Then(u Transform) Transform {
+	// Where two neighbouring corners of a square end up tells the eight
+	// transforms apart.
+	for c := range Transform(len(transformNames)) {
+		same := true
+		for _, p := range [][2]int{{0, 0}, {1, 0}} {
+			ux, uy := u.source(p[0], p[1], 1, 1)
+			tx, ty := t.source(ux, uy, 1, 1)
+			cx, cy := c.source(p[0], p[1], 1, 1)
+			same = same && tx == cx && ty == cy
+		}
+		if same {
+			return c
+		}
+	}
+	return Identity
+}
+
+// Inverse returns the transform that undoes t.
+func (t Transform) Inverse() Transform {
+	for c := range Transform(len(transformNames)) {
+		if t.Then(c) == Identity {
+			return c
+		}
+	}
+	return Identity
+}
+
+// MatchImages compares two hashed images and returns how far apart they
+// are on ImageDistance's scale. When both were hashed in every orientation,
+// the closest orientation of a is used, and the returned Transform tells
+// how b relates to a: b looks like a with that transform applied.
+func MatchImages(a, b HashedFile) (float64, Transform, bool) {
+	if len(a.Orientations) == 0 || len(b.Orientations) == 0 {
+		distance, ok := ImageDistance(a.PHash, b.PHash)
+		return distance, Identity, ok
+	}
+
+	best, bestTransform, found := 0.0, Identity, false
+	for t, hashes := range a.Orientations {
+		distance, ok := ImageDistance(hashes, b.PHash)
+		if ok && (!found || distance < best) {
+			best, bestTransform, found = distance, Transform(t), true
+		}
+	}
+	return best, bestTransform, found
+}
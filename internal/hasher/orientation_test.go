@@ -0,0 +1,29 @@
+package hasher
+
+import (
+	"image"
+	"testing"
+)
+
+func TestTransformThen(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+	same := func(a, b image.Image) bool {
+		x, y := a.(*image.RGBA), b.(*image.RGBA)
+		return x.Bounds() == y.Bounds() && string(x.Pix) == string(y.Pix)
+	}
+
+	for first := range Transform(8) {
+		for second := range Transform(8) {
+			combined := first.Then(second)
+			if !same(combined.apply(img), second.apply(first.apply(img))) {
+				t.Errorf("%v then %v gave %v", first, second, combined)
+			}
+		}
+		if first.Then(first.Inverse()) != Identity || first.Inverse().Then(first) != Identity {
+			t.Errorf("%v has inverse %v", first, first.Inverse())
+		}
+	}
+}
@@ -1,26 +1,39 @@
 package hasher
 
 import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"image"
 	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
-	"os"
+	"io"
+	"math/bits"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/corona10/goimagehash"
+	"github.com/corona10/goimagehash/transforms"
+	"github.com/nfnt/resize"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
 )
 
+// imageExtensions lists the formats with a registered decoder. HEIC has no
+// decoder in Go, so HEIC files are compared byte for byte like any other.
 var imageExtensions = map[string]bool{
 	".jpg":  true,
 	".jpeg": true,
 	".png":  true,
 	".gif":  true,
 	".bmp":  true,
+	".tif":  true,
 	".tiff": true,
 	".webp": true,
-	".heic": true,
 }
 
 func isImage(filename string) bool {
@@ -28,28 +41,229 @@ func isImage(filename string) bool {
 	return imageExtensions[ext]
 }
 
-func perceptualHashImage(path string) (*goimagehash.ImageHash, error) {
-	// #nosec G304 - path comes from filesystem scan, not user input
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
+type PHashAlgorithm string
+
+const (
+	AverageHash            PHashAlgorithm = "average"
+	DifferenceHash         PHashAlgorithm = "difference"
+	PerceptionHash         PHashAlgorithm = "perception"
+	WaveletHash            PHashAlgorithm = "wavelet"
+	ExtendedPerceptionHash PHashAlgorithm = "extended-perception"
+)
+
+var PHashAlgorithms = []PHashAlgorithm{AverageHash, DifferenceHash, PerceptionHash, WaveletHash, ExtendedPerceptionHash}
+
+// ImageHash is a perceptual hash together with the algorithm that made it.
+// Hashes are only comparable when their algorithms match.
+type ImageHash struct {
+	Algorithm PHashAlgorithm
+	// Hash holds the bits, most significant first, 64 per word.
+	Hash []uint64
+}
+
+// Bits returns the length of the hash in bits.
+func (h ImageHash) Bits() int {
+	return 64 * len(h.Hash)
+}
+
+// Distance returns the number of differing bits, or false when the hashes
+// were made by different algorithms.
+func (h ImageHash) Distance(other ImageHash) (int, bool) {
+	if h.Algorithm != other.Algorithm || len(h.Hash) != len(other.Hash) {
+		return 0, false
+	}
+
+	distance := 0
+	for i := range h.Hash {
+		distance += bits.OnesCount64(h.Hash[i] ^ other.Hash[i])
+	}
+	return distance, true
+}
+
+// String returns the hash as "algorithm:hex".
+func (h ImageHash) String() string {
+	var sb strings.Builder
+	sb.WriteString(string(h.Algorithm))
+	sb.WriteByte(':')
+	for _, word := range h.Hash {
+		fmt.Fprintf(&sb, "%016x", word)
+	}
+	return sb.String()
+}
+
+// ParseImageHash reads a hash written by ImageHash.String.
+func ParseImageHash(s string) (ImageHash, error) {
+	name, digits, ok := strings.Cut(s, ":")
+	if !ok || !slices.Contains(PHashAlgorithms, PHashAlgorithm(name)) {
+		return ImageHash{}, fmt.Errorf("invalid image hash %q", s)
+	}
+
+	raw, err := hex.DecodeString(digits)
+	if err != nil || len(raw) == 0 || len(raw)%8 != 0 {
+		return ImageHash{}, fmt.Errorf("invalid image hash %q", s)
+	}
+
+	h := ImageHash{Algorithm: PHashAlgorithm(name)}
+	for i := 0; i < len(raw); i += 8 {
+		var word uint64
+		for _, b := range raw[i : i+8] {
+			word = word<<8 | uint64(b)
+		}
+		h.Hash = append(h.Hash, word)
+	}
+	return h, nil
+}
+
+// ImageDistance compares two images hashed with the same algorithms. Each
+// algorithm's distance is scaled to 64 bits, so one threshold fits all hash
+// lengths, and the largest is returned: with several algorithms, images
+// are only as close as the algorithm that agrees least. It returns false
+// when the images were hashed differently.
+func ImageDistance(a, b []ImageHash) (float64, bool) {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0, false
+	}
+
+	var worst float64
+	for i := range a {
+		distance, ok := a[i].Distance(b[i])
+		if !ok {
+			return 0, false
+		}
+		worst = max(worst, float64(distance)*64/float64(a[i].Bits()))
+	}
+	return worst, true
+}
+
+func (o Options) phashAlgorithms() []PHashAlgorithm {
+	if len(o.PHash) == 0 {
+		return []PHashAlgorithm{DifferenceHash}
 	}
-	defer file.Close()
+	return o.PHash
+}
+
+// errUndecodable wraps the error of an image that could not be decoded.
+var errUndecodable = errors.New("cannot decode image")
+
+// perceptualHashImage decodes the image r reads and sets its description
+// and hashes on h, one hash per algorithm, and with Options.Invariant the
+// hashes of every orientation as well.
+func perceptualHashImage(h *HashedFile, r io.Reader, opts Options) error {
+	reader := bufio.NewReaderSize(r, readBufferSize)
+	// Peek returns what it could read with an error, which Decode reports.
+	// The header is only valid until decoding reads on.
+	header, _ := reader.Peek(exifSearchSize)
+	orientation := jpegOrientation(header)
+	info := ImageInfo{BitDepth: headerBitDepth(header), Quality: jpegQuality(header)}
 
-	img, _, err := image.Decode(file)
+	img, format, err := image.Decode(reader)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("%w: %w", errUndecodable, err)
+	}
+	info.Format = format
+	if info.BitDepth == 0 {
+		info.BitDepth = bitDepth(img)
+	}
+	h.Image = info
+
+	// Hash the image as it is displayed, so a camera original tagged as
+	// rotated matches a copy with the rotation applied to the pixels.
+	img = orientation.apply(img)
+	h.Image.Width, h.Image.Height = img.Bounds().Dx(), img.Bounds().Dy()
+
+	if !opts.Invariant {
+		h.PHash, err = hashImageAll(img, opts)
+		return err
 	}
 
-	hash, err := goimagehash.DifferenceHash(img)
+	var all [][]ImageHash
+	for _, oriented := range orientations(img) {
+		hashes, err := hashImageAll(oriented, opts)
+		if err != nil {
+			return err
+		}
+		all = append(all, hashes)
+	}
+	h.PHash, h.Orientations = all[Identity], all
+	return nil
+}
+
+func hashImageAll(img image.Image, opts Options) ([]ImageHash, error) {
+	var hashes []ImageHash
+	for _, algorithm := range opts.phashAlgorithms() {
+		hash, err := hashImage(img, algorithm)
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, nil
+}
+
+func hashImage(img image.Image, algorithm PHashAlgorithm) (ImageHash, error) {
+	h := ImageHash{Algorithm: algorithm}
+
+	var hash *goimagehash.ImageHash
+	var err error
+	switch algorithm {
+	case AverageHash:
+		hash, err = goimagehash.AverageHash(img)
+	case DifferenceHash:
+		hash, err = goimagehash.DifferenceHash(img)
+	case PerceptionHash:
+		hash, err = goimagehash.PerceptionHash(img)
+	case WaveletHash:
+		h.Hash = waveletHash(img)
+		return h, nil
+	case ExtendedPerceptionHash:
+		ext, err := goimagehash.ExtPerceptionHash(img, 16, 16)
+		if err != nil {
+			return ImageHash{}, err
+		}
+		h.Hash = ext.GetHash()
+		return h, nil
+	default:
+		return ImageHash{}, fmt.Errorf("unknown perceptual hash %q", algorithm)
+	}
 	if err != nil {
-		return nil, err
+		return ImageHash{}, err
 	}
 
-	return hash, nil
+	h.Hash = []uint64{hash.GetHash()}
+	return h, nil
 }
 
-func hammingDistance(hash1, hash2 *goimagehash.ImageHash) int {
-	distance, _ := hash1.Distance(hash2)
-	return distance
+// waveletHash is a 64-bit Haar wavelet hash: the image is scaled to 64x64
+// grey levels and decomposed three times, keeping the low-frequency band
+// each time. Each bit of the resulting 8x8 band tells whether it is above
+// the band's median, which makes the hash robust to brightness and
+// contrast changes.
+func waveletHash(img image.Image) []uint64 {
+	const size, side = 64, 8
+
+	pixels := transforms.Rgb2Gray(resize.Resize(size, size, img, resize.Bilinear))
+	for n := size; n > side; n /= 2 {
+		for y := 0; y < n/2; y++ {
+			for x := 0; x < n/2; x++ {
+				sum := pixels[2*y][2*x] + pixels[2*y][2*x+1] + pixels[2*y+1][2*x] + pixels[2*y+1][2*x+1]
+				pixels[y][x] = sum / 2
+			}
+		}
+	}
+
+	band := make([]float64, 0, side*side)
+	for y := 0; y < side; y++ {
+		band = append(band, pixels[y][:side]...)
+	}
+	sorted := slices.Clone(band)
+	slices.Sort(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var hash uint64
+	for i, p := range band {
+		if p > median {
+			hash |= 1 << (len(band) - i - 1)
+		}
+	}
+	return []uint64{hash}
 }
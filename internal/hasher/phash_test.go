@@ -0,0 +1,123 @@
+package hasher
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// storedImage is a 64x32 picture with no symmetry: a diagonal gradient,
+// a bright block near the top left corner and a dark bar near the bottom.
+func storedImage() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 32))
+	for y := range 32 {
+		for x := range 64 {
+			v := uint8(20 + 2*x + 3*y)
+			switch {
+			case x >= 4 && x < 20 && y >= 4 && y < 14:
+				v = 250
+			case x >= 36 && x < 60 && y >= 22 && y < 28:
+				v = 10
+			}
+			img.Set(x, y, color.RGBA{R: v, G: v / 2, B: 255 - v, A: 255})
+		}
+	}
+	return img
+}
+
+// displayed places the stored pixels the way an EXIF Orientation value says
+// the image is shown.
+func displayed(stored *image.RGBA, value int) *image.RGBA {
+	w, h := stored.Bounds().Dx(), stored.Bounds().Dy()
+	size := image.Rect(0, 0, w, h)
+	if value >= 5 {
+		size = image.Rect(0, 0, h, w)
+	}
+
+	img := image.NewRGBA(size)
+	for y := range size.Dy() {
+		for x := range size.Dx() {
+			sx, sy := x, y
+			switch value {
+			case 2:
+				sx = w - 1 - x
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sy = h - 1 - y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			img.Set(x, y, stored.At(sx, sy))
+		}
+	}
+	return img
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// withOrientation inserts an EXIF segment with the given Orientation value
+// right after the start of an encoded JPEG.
+func withOrientation(data []byte, value uint16) []byte {
+	tagged := appendSegment([]byte{0xFF, 0xD8}, 0xE1, exifPayload(binary.BigEndian, 3, value))
+	return append(tagged, data[2:]...)
+}
+
+func TestPerceptualHashFollowsOrientation(t *testing.T) {
+	// JPEG compression is lossy, so the copies may differ by a few bits;
+	// this is the default --threshold.
+	const tolerance = 5
+	opts := Options{PHash: PHashAlgorithms}
+	stored := storedImage()
+	encoded := encodeJPEG(t, stored)
+
+	for value := 1; value <= 8; value++ {
+		shown := displayed(stored, value)
+
+		var want HashedFile
+		if err := perceptualHashImage(&want, bytes.NewReader(encodeJPEG(t, shown)), opts); err != nil {
+			t.Fatal(err)
+		}
+		var got HashedFile
+		if err := perceptualHashImage(&got, bytes.NewReader(withOrientation(encoded, uint16(value))), opts); err != nil {
+			t.Fatal(err)
+		}
+
+		if got.Image.Width != shown.Bounds().Dx() || got.Image.Height != shown.Bounds().Dy() {
+			t.Errorf("orientation %d: got %dx%d, want %dx%d", value, got.Image.Width, got.Image.Height, shown.Bounds().Dx(), shown.Bounds().Dy())
+		}
+		distance, ok := ImageDistance(got.PHash, want.PHash)
+		if !ok || distance > tolerance {
+			t.Errorf("orientation %d: tagged original is %v bits from the rotated copy", value, distance)
+		}
+
+		// Without the tag the stored pixels are hashed, which must not
+		// match a copy that was actually turned.
+		if value == 1 {
+			continue
+		}
+		var untagged HashedFile
+		if err := perceptualHashImage(&untagged, bytes.NewReader(encoded), opts); err != nil {
+			t.Fatal(err)
+		}
+		if distance, ok := ImageDistance(untagged.PHash, want.PHash); ok && distance <= tolerance {
+			t.Errorf("orientation %d: untagged original is only %v bits from the rotated copy", value, distance)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package hasher
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// sniffLen is how much of a file is used to detect its type, the same
+// amount http.DetectContentType considers.
+const sniffLen = 512
+
+type Classify string
+
+const (
+	// ClassifyExtension treats files as images by their extension alone.
+	ClassifyExtension Classify = "extension"
+	// ClassifyContent treats files as images by their first bytes alone,
+	// whatever they are named.
+	ClassifyContent Classify = "content"
+	// ClassifyBoth treats files as images when either their extension or
+	// their first bytes say so.
+	ClassifyBoth Classify = "both"
+)
+
+var ClassifyModes = []Classify{ClassifyExtension, ClassifyContent, ClassifyBoth}
+
+// signatures covers formats http.DetectContentType does not know. Each
+// pattern must appear at offset in the file.
+var signatures = []struct {
+	offset  int
+	pattern string
+	mime    string
+}{
+	{0, "II*\x00", "image/tiff"},
+	{0, "MM\x00*", "image/tiff"},
+	{4, "ftypheic", "image/heic"},
+	{4, "ftypheix", "image/heic"},
+	{4, "ftypmif1", "image/heif"},
+	{4, "ftypavif", "image/avif"},
+	{0, "fLaC", "audio/flac"},
+}
+
+// decodableTypes are the MIME types of the registered image decoders.
+var decodableTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/bmp":  true,
+	"image/tiff": true,
+	"image/webp": true,
+}
+
+// detectType returns the MIME type of a file that starts with data.
+func detectType(data []byte) string {
+	for _, s := range signatures {
+		if len(data) >= s.offset+len(s.pattern) && bytes.HasPrefix(data[s.offset:], []byte(s.pattern)) {
+			return s.mime
+		}
+	}
+	mime := http.DetectContentType(data)
+	if mime == "application/octet-stream" && isMPEGFrame(data) {
+		// MP3 without an ID3v2 tag.
+		return "audio/mpeg"
+	}
+	return mime
+}
+
+// classify tells whether a file may be an image, audio or text, and if so
+// whether its content must be checked before it is hashed as one.
+func (o Options) classify(path string) (candidate, sniff bool) {
+	if o.Exact && !o.Audio && !o.Fingerprint && !o.TextNormalize {
+		return false, false
+	}
+	byName := o.mediaKind(path, "", false) != noMedia
+	switch o.Classify {
+	case ClassifyContent:
+		return true, true
+	case ClassifyBoth:
+		return true, !byName
+	}
+	return byName, false
+}
+
+// headBuffer keeps the first sniffLen bytes written to it.
+type headBuffer struct {
+	data []byte
+}
+
+func (b *headBuffer) Write(p []byte) (int, error) {
+	if n := sniffLen - len(b.data); n > 0 {
+		b.data = append(b.data, p[:min(n, len(p))]...)
+	}
+	return len(p), nil
+}
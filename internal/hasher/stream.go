@@ -0,0 +1,376 @@
+package hasher
+
+import (
+	"context"
+	"doppel/internal/scanner"
+	"errors"
+	"fmt"
+	"iter"
+	"runtime"
+	"slices"
+	"sync"
+)
+
+// Batch is a set of hashed files that will not change any more: every file
+// of one size. Exact duplicates can be detected within a batch as soon as it
+// arrives; images still have to be compared across batches.
+type Batch struct {
+	Size  int64
+	Files []HashedFile
+}
+
+type hashJob struct {
+	file   scanner.FileInfo
+	media  bool
+	sha256 bool
+	// sniff makes the media hash depend on the file's content being an
+	// image, audio or text.
+	sniff bool
+}
+
+type sizeClass struct {
+	members []scanner.FileInfo
+	results map[string]*HashedFile
+	failed  map[string]bool
+	pending int
+}
+
+type pipeline struct {
+	ctx  context.Context
+	opts Options
+	out  chan Batch
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	queue    []hashJob
+	classes  map[int64]*sizeClass
+	scanDone bool
+}
+
+// HashStream hashes files while they are still being produced. Files are
+// grouped by size as they arrive; content hashing starts as soon as a second
+// file of the same size shows up, and images are perceptually hashed right
+// away. Once files is exhausted, each size class is emitted as a Batch when
+// its last hash completes.
+func HashStream(ctx context.Context, files iter.Seq[scanner.FileInfo], opts Options) iter.Seq[Batch] {
+	return func(yield func(Batch) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		workers := opts.Workers
+		if workers <= 0 {
+			workers = runtime.NumCPU()
+		}
+
+		p := &pipeline{
+			ctx:     ctx,
+			opts:    opts,
+			out:     make(chan Batch, workers),
+			classes: make(map[int64]*sizeClass),
+		}
+		p.cond = sync.NewCond(&p.mu)
+
+		stop := context.AfterFunc(ctx, func() {
+			p.mu.Lock()
+			p.cond.Broadcast()
+			p.mu.Unlock()
+		})
+		defer stop()
+
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				p.work()
+			}()
+		}
+
+		go func() {
+			for file := range files {
+				if ctx.Err() != nil {
+					break
+				}
+				p.add(file)
+			}
+			p.finishScan()
+			wg.Wait()
+			close(p.out)
+		}()
+
+		for batch := range p.out {
+			if !yield(batch) {
+				cancel()
+				for range p.out {
+				}
+				return
+			}
+		}
+	}
+}
+
+func (p *pipeline) add(file scanner.FileInfo) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	c, ok := p.classes[file.Size]
+	if !ok {
+		c = &sizeClass{
+			results: make(map[string]*HashedFile),
+			failed:  make(map[string]bool),
+		}
+		p.classes[file.Size] = c
+	}
+	c.members = append(c.members, file)
+
+	media, sniff := p.opts.classify(file.Path)
+	switch len(c.members) {
+	case 1:
+		if media {
+			p.submit(c, hashJob{file: file, media: true, sniff: sniff})
+		}
+	case 2:
+		// The first file of this size now needs a content hash too.
+		p.submit(c, hashJob{file: c.members[0], sha256: true})
+		p.submit(c, hashJob{file: file, media: media, sniff: sniff, sha256: true})
+	default:
+		p.submit(c, hashJob{file: file, media: media, sniff: sniff, sha256: true})
+	}
+}
+
+func (p *pipeline) submit(c *sizeClass, job hashJob) {
+	if p.opts.Progress != nil {
+		// A sniffed file's media hash read is queued once its content
+		// shows it is an image, audio or text.
+		var reads int64
+		if job.media && !job.sniff {
+			reads++
+		}
+		if job.sha256 {
+			reads++
+		}
+		p.opts.Progress.Queued(reads * job.file.Size)
+	}
+	c.pending++
+	p.queue = append(p.queue, job)
+	p.cond.Signal()
+}
+
+func (p *pipeline) finishScan() {
+	p.mu.Lock()
+	p.scanDone = true
+	var ready []Batch
+	for size, c := range p.classes {
+		if c.pending == 0 {
+			ready = append(ready, p.take(size, c))
+		}
+	}
+	p.cond.Broadcast()
+	p.mu.Unlock()
+
+	for _, batch := range ready {
+		p.send(batch)
+	}
+}
+
+func (p *pipeline) work() {
+	for {
+		p.mu.Lock()
+		for len(p.queue) == 0 && p.ctx.Err() == nil && !(p.scanDone && p.idle()) {
+			p.cond.Wait()
+		}
+		if len(p.queue) == 0 || p.ctx.Err() != nil {
+			p.cond.Broadcast()
+			p.mu.Unlock()
+			return
+		}
+		job := p.queue[len(p.queue)-1]
+		p.queue = p.queue[:len(p.queue)-1]
+		p.mu.Unlock()
+
+		result, err := hashOne(p.ctx, job, p.opts)
+
+		p.mu.Lock()
+		c := p.classes[job.file.Size]
+		switch {
+		case err != nil:
+			// A failed content hash alone does not disqualify an image,
+			// audio or text file.
+			if candidate, _ := p.opts.classify(job.file.Path); job.media || !candidate {
+				c.failed[job.file.Path] = true
+			}
+			if p.ctx.Err() == nil {
+				p.opts.reportError(job.file.Path, err)
+			}
+		case c.results[job.file.Path] != nil:
+			mergeResult(c.results[job.file.Path], result)
+		default:
+			c.results[job.file.Path] = result
+		}
+		c.pending--
+
+		var batch Batch
+		ready := c.pending == 0 && p.scanDone
+		if ready {
+			batch = p.take(job.file.Size, c)
+		}
+		p.cond.Broadcast()
+		p.mu.Unlock()
+
+		if ready {
+			p.send(batch)
+		}
+	}
+}
+
+// idle reports whether every size class has been emitted.
+func (p *pipeline) idle() bool {
+	return len(p.classes) == 0
+}
+
+// take removes a finished size class and builds its batch, in path order
+// so results do not depend on scheduling. Files whose hash failed are left
+// out, and so are undecodable images that turned out to need no content
+// hash. It must be called with p.mu held.
+func (p *pipeline) take(size int64, c *sizeClass) Batch {
+	delete(p.classes, size)
+
+	batch := Batch{Size: size}
+	for _, file := range c.members {
+		if h, ok := c.results[file.Path]; ok && !c.failed[file.Path] && (h.Hash != "" || h.IsImage || h.IsAudio || h.IsText) {
+			batch.Files = append(batch.Files, *h)
+		}
+	}
+	SortByPath(batch.Files)
+	return batch
+}
+
+// SortByPath puts hashed files in the order a directory walk lists them.
+func SortByPath(files []HashedFile) {
+	slices.SortFunc(files, func(a, b HashedFile) int {
+		return scanner.ComparePaths(a.FileInfo.Path, b.FileInfo.Path)
+	})
+}
+
+// send delivers a batch unless the pipeline was cancelled: a job cut short
+// by the cancellation may be missing from it.
+func (p *pipeline) send(batch Batch) {
+	if len(batch.Files) == 0 || p.ctx.Err() != nil {
+		return
+	}
+	select {
+	case p.out <- batch:
+	case <-p.ctx.Done():
+	}
+}
+
+func hashOne(ctx context.Context, job hashJob, opts Options) (*HashedFile, error) {
+	h := &HashedFile{FileInfo: job.file}
+	progress := opts.Progress
+	if progress != nil {
+		progress.Start(job.file.Path)
+	}
+
+	var cached HashedFile
+	if opts.Cache != nil {
+		cached, _ = opts.Cache.Lookup(job.file, opts)
+	}
+	computed := false
+
+	if job.media {
+		switch {
+		case len(cached.PHash) > 0:
+			h.PHash, h.Orientations, h.Image, h.MIME = cached.PHash, cached.Orientations, cached.Image, cached.MIME
+			h.IsImage = true
+			if !job.sniff {
+				skipRead(progress, job.file.Size)
+			}
+		case cached.IsText:
+			h.Text, h.MIME = cached.Text, cached.MIME
+			h.IsText = true
+			if !job.sniff {
+				skipRead(progress, job.file.Size)
+			}
+		case cached.IsAudio:
+			h.Audio, h.Fingerprint, h.Tags, h.MIME = cached.Audio, cached.Fingerprint, cached.Tags, cached.MIME
+			h.IsAudio = true
+			if !job.sniff {
+				skipRead(progress, job.file.Size)
+			}
+		default:
+			err := hashMedia(ctx, h, job.sniff, opts)
+			switch {
+			case err == nil:
+				computed = true
+			case errors.Is(err, errNotMedia):
+			case (errors.Is(err, errUndecodable) || errors.Is(err, errBadAudio)) && ctx.Err() == nil:
+				// Compare it byte for byte like any other file. The content
+				// hash is part of this job, or is submitted once another
+				// file of the same size shows up; until then there is
+				// nothing to compare it with.
+				if job.sha256 {
+					err = fmt.Errorf("%w; comparing it byte for byte", err)
+				} else {
+					err = fmt.Errorf("%w; treating it as an ordinary file", err)
+				}
+				opts.reportError(job.file.Path, err)
+			default:
+				return nil, err
+			}
+		}
+	}
+
+	if job.sha256 {
+		if cached.Hash != "" {
+			h.Hash = cached.Hash
+			if h.MIME == "" {
+				h.MIME = cached.MIME
+			}
+			skipRead(progress, job.file.Size)
+		} else {
+			hash, mime, err := hashFile(ctx, job.file.Path, opts)
+			if err != nil {
+				// An image, audio or text file is still usable without it.
+				if h.IsImage || h.IsAudio || h.IsText {
+					return h, nil
+				}
+				return nil, err
+			}
+			h.Hash, h.MIME = hash, mime
+			computed = true
+		}
+	}
+
+	if computed && opts.Cache != nil {
+		opts.Cache.Store(*h)
+	}
+	return h, nil
+}
+
+// skipRead accounts for a read that a cached hash made unnecessary.
+func skipRead(progress Progress, size int64) {
+	if progress != nil {
+		progress.Read(size)
+	}
+}
+
+func mergeResult(dst, src *HashedFile) {
+	if src.Hash != "" {
+		dst.Hash = src.Hash
+	}
+	if src.MIME != "" {
+		dst.MIME = src.MIME
+	}
+	if src.PHash != nil {
+		dst.PHash, dst.Orientations, dst.Image = src.PHash, src.Orientations, src.Image
+		dst.IsImage = true
+	}
+	if src.IsText {
+		dst.Text = src.Text
+		dst.IsText = true
+	}
+	if src.IsAudio {
+		dst.Audio, dst.Fingerprint, dst.Tags = src.Audio, src.Fingerprint, src.Tags
+		dst.IsAudio = true
+	}
+}
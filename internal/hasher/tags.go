@@ -0,0 +1,355 @@
+package hasher
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// Tags maps metadata names to values. Common fields get the same name in
+// every tag format: title, artist, album, albumartist, date, track, disc,
+// genre and comment. Other fields keep their name from the tag. The
+// "tags" entry lists the tag formats found, such as "ID3v2.3+ID3v1".
+type Tags map[string]string
+
+// add records a value unless an earlier tag already set the field, so the
+// richer tags at the start of a file win over trailing ones.
+func (t Tags) add(name, value string) {
+	value = strings.TrimSpace(strings.TrimRight(value, "\x00"))
+	if name == "" || value == "" {
+		return
+	}
+	if _, ok := t[name]; !ok {
+		t[name] = value
+	}
+}
+
+func (t Tags) addFormat(format string) {
+	if t["tags"] == "" {
+		t["tags"] = format
+	} else {
+		t["tags"] += "+" + format
+	}
+}
+
+// id3Names maps ID3v2 frame IDs, including the three-letter ones of
+// ID3v2.2, to common field names.
+var id3Names = map[string]string{
+	"TIT2": "title", "TT2": "title",
+	"TPE1": "artist", "TP1": "artist",
+	"TALB": "album", "TAL": "album",
+	"TPE2": "albumartist", "TP2": "albumartist",
+	"TYER": "date", "TDRC": "date", "TYE": "date",
+	"TRCK": "track", "TRK": "track",
+	"TPOS": "disc", "TPA": "disc",
+	"TCON": "genre", "TCO": "genre",
+	"COMM": "comment", "COM": "comment",
+}
+
+// vorbisNames maps Vorbis comment and APEv2 item names, lower-cased, to
+// common field names where they differ.
+var vorbisNames = map[string]string{
+	"tracknumber":  "track",
+	"discnumber":   "disc",
+	"year":         "date",
+	"album artist": "albumartist",
+}
+
+// id3v2Size returns the total size of an ID3v2 tag starting at data, or 0
+// when data does not start with one.
+func id3v2Size(header []byte) int64 {
+	if len(header) < 10 || string(header[:3]) != "ID3" || header[3] == 0xFF {
+		return 0
+	}
+	size := int64(syncsafe(header[6:10])) + 10
+	if header[5]&0x10 != 0 {
+		// Footer present.
+		size += 10
+	}
+	return size
+}
+
+func syncsafe(b []byte) uint32 {
+	return uint32(b[0]&0x7F)<<21 | uint32(b[1]&0x7F)<<14 | uint32(b[2]&0x7F)<<7 | uint32(b[3]&0x7F)
+}
+
+// parseID3v2 reads the text and comment frames of a complete ID3v2 tag.
+func parseID3v2(tag []byte, tags Tags) {
+	if len(tag) < 10 {
+		return
+	}
+	version, flags := tag[3], tag[5]
+	tags.addFormat(fmt.Sprintf("ID3v2.%d", version))
+
+	body := tag[10:min(len(tag), 10+int(syncsafe(tag[6:10])))]
+	if flags&0x80 != 0 && version < 4 {
+		body = removeUnsync(body)
+	}
+	if flags&0x40 != 0 && len(body) >= 4 {
+		// Skip the extended header.
+		size := int(binary.BigEndian.Uint32(body))
+		if version >= 4 {
+			size = int(syncsafe(body))
+		} else {
+			size += 4
+		}
+		body = body[min(size, len(body)):]
+	}
+
+	idLen, headerLen := 4, 10
+	if version == 2 {
+		idLen, headerLen = 3, 6
+	}
+	for len(body) >= headerLen && body[0] != 0 {
+		id := string(body[:idLen])
+		var size int
+		var frameFlags byte
+		switch version {
+		case 2:
+			size = int(body[3])<<16 | int(body[4])<<8 | int(body[5])
+		case 3:
+			size = int(binary.BigEndian.Uint32(body[4:]))
+			frameFlags = body[9]
+		default:
+			size = int(syncsafe(body[4:]))
+			frameFlags = body[9]
+		}
+		if size <= 0 || headerLen+size > len(body) {
+			return
+		}
+		data := body[headerLen : headerLen+size]
+		body = body[headerLen+size:]
+
+		// Compressed and encrypted frames are skipped.
+		switch {
+		case version == 3 && frameFlags&0xC0 != 0, version >= 4 && frameFlags&0x0C != 0:
+			continue
+		case version >= 4:
+			if frameFlags&0x02 != 0 {
+				data = removeUnsync(data)
+			}
+			if frameFlags&0x01 != 0 && len(data) >= 4 {
+				// Data length indicator.
+				data = data[4:]
+			}
+		}
+		addID3Frame(id, data, tags)
+	}
+}
+
+func addID3Frame(id string, data []byte, tags Tags) {
+	if len(data) < 1 {
+		return
+	}
+	encoding, text := data[0], data[1:]
+
+	switch {
+	case id == "COMM" || id == "COM":
+		// Language, then a description and the comment itself.
+		if len(text) < 3 {
+			return
+		}
+		parts := splitID3Text(encoding, text[3:])
+		if len(parts) >= 2 && parts[0] == "" {
+			tags.add("comment", parts[1])
+		}
+	case id == "TXXX" || id == "TXX":
+		parts := splitID3Text(encoding, text)
+		if len(parts) >= 2 {
+			tags.add(strings.ToLower(parts[0]), parts[1])
+		}
+	case id[0] == 'T':
+		name, ok := id3Names[id]
+		if !ok {
+			name = id
+		}
+		tags.add(name, strings.Join(splitID3Text(encoding, text), "; "))
+	}
+}
+
+// splitID3Text decodes the null-separated strings of a text frame.
+func splitID3Text(encoding byte, data []byte) []string {
+	var parts []string
+	switch encoding {
+	case 1, 2:
+		var units []uint16
+		bigEndian := encoding == 2
+		for i := 0; i+1 < len(data); i += 2 {
+			u := binary.LittleEndian.Uint16(data[i:])
+			if bigEndian {
+				u = binary.BigEndian.Uint16(data[i:])
+			}
+			switch {
+			case u == 0xFEFF && len(units) == 0:
+				continue
+			case u == 0xFFFE && len(units) == 0:
+				bigEndian = !bigEndian
+				continue
+			case u == 0:
+				parts = append(parts, string(utf16.Decode(units)))
+				units = nil
+				continue
+			}
+			units = append(units, u)
+		}
+		parts = append(parts, string(utf16.Decode(units)))
+	case 3:
+		parts = strings.Split(string(data), "\x00")
+	default:
+		for _, part := range bytes.Split(data, []byte{0}) {
+			parts = append(parts, latin1(part))
+		}
+	}
+	for len(parts) > 1 && parts[len(parts)-1] == "" {
+		parts = parts[:len(parts)-1]
+	}
+	return parts
+}
+
+// removeUnsync undoes ID3v2 unsynchronisation, which inserts a zero byte
+// after every 0xFF.
+func removeUnsync(data []byte) []byte {
+	return bytes.ReplaceAll(data, []byte{0xFF, 0x00}, []byte{0xFF})
+}
+
+func latin1(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// id3v1Size is the size of an ID3v1 tag, which fills the last bytes of a
+// file.
+const id3v1Size = 128
+
+// parseID3v1 reads an ID3v1 or ID3v1.1 tag.
+func parseID3v1(tag []byte, tags Tags) {
+	if len(tag) != id3v1Size || string(tag[:3]) != "TAG" {
+		return
+	}
+	field := func(b []byte) string {
+		if i := bytes.IndexByte(b, 0); i >= 0 {
+			b = b[:i]
+		}
+		return latin1(b)
+	}
+
+	tags.addFormat("ID3v1")
+	tags.add("title", field(tag[3:33]))
+	tags.add("artist", field(tag[33:63]))
+	tags.add("album", field(tag[63:93]))
+	tags.add("date", field(tag[93:97]))
+	comment := tag[97:127]
+	if comment[28] == 0 && comment[29] != 0 {
+		tags.add("track", fmt.Sprint(comment[29]))
+		comment = comment[:28]
+	}
+	tags.add("comment", field(comment))
+	if tag[127] != 0xFF {
+		tags.add("genre", fmt.Sprintf("(%d)", tag[127]))
+	}
+}
+
+// apeFooterSize is the size of the footer that ends an APEv2 tag, and of
+// the optional header that starts it.
+const apeFooterSize = 32
+
+// apeSize returns the total size of an APEv2 tag whose footer is given, or
+// 0 when it is not one.
+func apeSize(footer []byte) int64 {
+	if len(footer) != apeFooterSize || string(footer[:8]) != "APETAGEX" {
+		return 0
+	}
+	size := int64(binary.LittleEndian.Uint32(footer[12:]))
+	if binary.LittleEndian.Uint32(footer[20:])&(1<<31) != 0 {
+		size += apeFooterSize
+	}
+	return size
+}
+
+// parseAPE reads the text items of a complete APEv2 tag, with or without
+// its header.
+func parseAPE(tag []byte, tags Tags) {
+	if len(tag) < apeFooterSize {
+		return
+	}
+	footer := tag[len(tag)-apeFooterSize:]
+	count := int(binary.LittleEndian.Uint32(footer[16:]))
+	items := tag[:len(tag)-apeFooterSize]
+	if bytes.HasPrefix(items, []byte("APETAGEX")) {
+		items = items[min(apeFooterSize, len(items)):]
+	}
+	tags.addFormat("APEv2")
+
+	for range count {
+		if len(items) < 9 {
+			return
+		}
+		size := int(binary.LittleEndian.Uint32(items))
+		flags := binary.LittleEndian.Uint32(items[4:])
+		end := bytes.IndexByte(items[8:], 0)
+		if end < 0 || 8+end+1+size > len(items) {
+			return
+		}
+		key := strings.ToLower(string(items[8 : 8+end]))
+		value := items[8+end+1 : 8+end+1+size]
+		items = items[8+end+1+size:]
+
+		// Only UTF-8 text items; binary items and links are skipped.
+		if flags&0x06 != 0 {
+			continue
+		}
+		if name, ok := vorbisNames[key]; ok {
+			key = name
+		}
+		tags.add(key, strings.ReplaceAll(string(value), "\x00", "; "))
+	}
+}
+
+// parseVorbisComment reads a FLAC VORBIS_COMMENT block.
+func parseVorbisComment(block []byte, tags Tags) {
+	if len(block) < 8 {
+		return
+	}
+	tags.addFormat("Vorbis")
+	vendor := int(binary.LittleEndian.Uint32(block))
+	if 4+vendor+4 > len(block) {
+		return
+	}
+	rest := block[4+vendor:]
+	count := int(binary.LittleEndian.Uint32(rest))
+	rest = rest[4:]
+
+	// A field may be repeated for several values.
+	var keys []string
+	values := make(map[string][]string)
+	for range count {
+		if len(rest) < 4 {
+			break
+		}
+		size := int(binary.LittleEndian.Uint32(rest))
+		if 4+size > len(rest) {
+			break
+		}
+		key, value, ok := strings.Cut(string(rest[4:4+size]), "=")
+		rest = rest[4+size:]
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(key)
+		if name, ok := vorbisNames[key]; ok {
+			key = name
+		}
+		if _, ok := values[key]; !ok {
+			keys = append(keys, key)
+		}
+		values[key] = append(values[key], value)
+	}
+	for _, key := range keys {
+		tags.add(key, strings.Join(values[key], "; "))
+	}
+}
@@ -0,0 +1,232 @@
+package hasher
+
+import (
+	"bytes"
+	"encoding/binary"
+	"maps"
+	"testing"
+	"unicode/utf16"
+)
+
+// id3v2Frame builds a frame for an ID3v2 tag of the given major version.
+func id3v2Frame(version byte, id string, data []byte) []byte {
+	var frame []byte
+	switch version {
+	case 2:
+		frame = append([]byte(id), byte(len(data)>>16), byte(len(data)>>8), byte(len(data)))
+	case 3:
+		frame = binary.BigEndian.AppendUint32([]byte(id), uint32(len(data)))
+		frame = append(frame, 0, 0)
+	default:
+		frame = append([]byte(id), syncsafeBytes(len(data))...)
+		frame = append(frame, 0, 0)
+	}
+	return append(frame, data...)
+}
+
+// id3v2Tag builds an ID3v2 tag holding frames, followed by some padding.
+func id3v2Tag(version, flags byte, frames ...[]byte) []byte {
+	var body []byte
+	for _, frame := range frames {
+		body = append(body, frame...)
+	}
+	body = append(body, make([]byte, 16)...)
+	tag := append([]byte{'I', 'D', '3', version, 0, flags}, syncsafeBytes(len(body))...)
+	return append(tag, body...)
+}
+
+func syncsafeBytes(n int) []byte {
+	return []byte{byte(n >> 21 & 0x7F), byte(n >> 14 & 0x7F), byte(n >> 7 & 0x7F), byte(n & 0x7F)}
+}
+
+// latin1Text and utf16Text build the data of an ID3v2 text frame.
+func latin1Text(s string) []byte {
+	return append([]byte{0}, s...)
+}
+
+func utf16Text(s string) []byte {
+	data := []byte{1, 0xFF, 0xFE}
+	for _, u := range utf16.Encode([]rune(s)) {
+		data = binary.LittleEndian.AppendUint16(data, u)
+	}
+	return data
+}
+
+// id3v1Tag builds an ID3v1.1 tag, with the track number when it is not 0.
+func id3v1Tag(title, artist, album, year, comment string, track, genre byte) []byte {
+	tag := make([]byte, id3v1Size)
+	copy(tag, "TAG")
+	copy(tag[3:33], title)
+	copy(tag[33:63], artist)
+	copy(tag[63:93], album)
+	copy(tag[93:97], year)
+	copy(tag[97:127], comment)
+	if track != 0 {
+		tag[125], tag[126] = 0, track
+	}
+	tag[127] = genre
+	return tag
+}
+
+type apeItem struct {
+	key, value string
+	flags      uint32
+}
+
+// apeTag builds an APEv2 tag, with its optional header when header is set.
+func apeTag(header bool, items ...apeItem) []byte {
+	var body []byte
+	for _, item := range items {
+		body = binary.LittleEndian.AppendUint32(body, uint32(len(item.value)))
+		body = binary.LittleEndian.AppendUint32(body, item.flags)
+		body = append(body, item.key...)
+		body = append(body, 0)
+		body = append(body, item.value...)
+	}
+
+	frame := func(flags uint32) []byte {
+		b := append([]byte("APETAGEX"), 0xD0, 0x07, 0, 0)
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(body)+apeFooterSize))
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(items)))
+		b = binary.LittleEndian.AppendUint32(b, flags)
+		return append(b, make([]byte, 8)...)
+	}
+
+	var tag []byte
+	var flags uint32
+	if header {
+		flags = 1 << 31
+		tag = frame(flags | 1<<29)
+	}
+	tag = append(tag, body...)
+	return append(tag, frame(flags)...)
+}
+
+// vorbisComment builds the body of a FLAC VORBIS_COMMENT block.
+func vorbisComment(comments ...string) []byte {
+	vendor := "test encoder"
+	block := binary.LittleEndian.AppendUint32(nil, uint32(len(vendor)))
+	block = append(block, vendor...)
+	block = binary.LittleEndian.AppendUint32(block, uint32(len(comments)))
+	for _, comment := range comments {
+		block = binary.LittleEndian.AppendUint32(block, uint32(len(comment)))
+		block = append(block, comment...)
+	}
+	return block
+}
+
+func TestParseID3v2(t *testing.T) {
+	tests := []struct {
+		name string
+		tag  []byte
+		want Tags
+	}{
+		{
+			"ID3v2.2",
+			id3v2Tag(2, 0,
+				id3v2Frame(2, "TT2", latin1Text("Title")),
+				id3v2Frame(2, "TP1", latin1Text("Artist")),
+				id3v2Frame(2, "TRK", latin1Text("3/12")),
+			),
+			Tags{"tags": "ID3v2.2", "title": "Title", "artist": "Artist", "track": "3/12"},
+		},
+		{
+			"ID3v2.3 with UTF-16, a comment and a user field",
+			id3v2Tag(3, 0,
+				id3v2Frame(3, "TIT2", utf16Text("Tïtle ♪")),
+				id3v2Frame(3, "TALB", latin1Text("Caf\xe9")),
+				id3v2Frame(3, "COMM", append([]byte{0}, "eng\x00A comment"...)),
+				id3v2Frame(3, "TXXX", latin1Text("MOOD\x00calm")),
+				id3v2Frame(3, "APIC", []byte{0, 1, 2, 3}),
+			),
+			Tags{"tags": "ID3v2.3", "title": "Tïtle ♪", "album": "Café", "comment": "A comment", "mood": "calm"},
+		},
+		{
+			"ID3v2.4 with UTF-8 and several values",
+			id3v2Tag(4, 0,
+				id3v2Frame(4, "TPE1", append([]byte{3}, "One\x00Two"...)),
+				id3v2Frame(4, "TDRC", append([]byte{3}, "2021"...)),
+				id3v2Frame(4, "TSSE", latin1Text("encoder")),
+			),
+			Tags{"tags": "ID3v2.4", "artist": "One; Two", "date": "2021", "TSSE": "encoder"},
+		},
+		{
+			"ID3v2.3 unsynchronised",
+			id3v2Tag(3, 0x80, bytes.ReplaceAll(
+				id3v2Frame(3, "TIT2", latin1Text("\xffTitle")),
+				[]byte{0xFF}, []byte{0xFF, 0x00},
+			)),
+			Tags{"tags": "ID3v2.3", "title": "ÿTitle"},
+		},
+	}
+
+	for _, tt := range tests {
+		tags := Tags{}
+		parseID3v2(tt.tag, tags)
+		if !maps.Equal(tags, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, tags, tt.want)
+		}
+		if got := id3v2Size(tt.tag); got != int64(len(tt.tag)) {
+			t.Errorf("%s: size is %d, want %d", tt.name, got, len(tt.tag))
+		}
+	}
+}
+
+func TestParseID3v1(t *testing.T) {
+	tags := Tags{}
+	parseID3v1(id3v1Tag("Title", "Artist", "Album", "1999", "Comment", 7, 17), tags)
+	want := Tags{"tags": "ID3v1", "title": "Title", "artist": "Artist", "album": "Album", "date": "1999", "comment": "Comment", "track": "7", "genre": "(17)"}
+	if !maps.Equal(tags, want) {
+		t.Errorf("got %v, want %v", tags, want)
+	}
+
+	// ID3v1.0 has no track, and genre 255 means none.
+	tags = Tags{}
+	parseID3v1(id3v1Tag("Title", "", "", "", "A comment that fills all thirty", 0, 0xFF), tags)
+	want = Tags{"tags": "ID3v1", "title": "Title", "comment": "A comment that fills all thirty"[:30]}
+	if !maps.Equal(tags, want) {
+		t.Errorf("got %v, want %v", tags, want)
+	}
+}
+
+func TestParseAPE(t *testing.T) {
+	items := []apeItem{
+		{"Title", "Title", 0},
+		{"Artist", "One\x00Two", 0},
+		{"Track", "5", 0},
+		{"Album Artist", "Someone", 0},
+		{"Cover Art (Front)", "\x00\x01binary", 1 << 1},
+	}
+	want := Tags{"tags": "APEv2", "title": "Title", "artist": "One; Two", "track": "5", "albumartist": "Someone"}
+
+	for _, header := range []bool{false, true} {
+		tag := apeTag(header, items...)
+		if got := apeSize(tag[len(tag)-apeFooterSize:]); got != int64(len(tag)) {
+			t.Errorf("header %v: size is %d, want %d", header, got, len(tag))
+		}
+		tags := Tags{}
+		parseAPE(tag, tags)
+		if !maps.Equal(tags, want) {
+			t.Errorf("header %v: got %v, want %v", header, tags, want)
+		}
+	}
+}
+
+func TestParseVorbisComment(t *testing.T) {
+	tags := Tags{}
+	parseVorbisComment(vorbisComment("TITLE=Title", "ARTIST=One", "artist=Two", "TRACKNUMBER=4", "not a comment", "YEAR=2001"), tags)
+	want := Tags{"tags": "Vorbis", "title": "Title", "artist": "One; Two", "track": "4", "date": "2001"}
+	if !maps.Equal(tags, want) {
+		t.Errorf("got %v, want %v", tags, want)
+	}
+}
+
+func TestTagsFirstWins(t *testing.T) {
+	tags := Tags{}
+	parseID3v2(id3v2Tag(3, 0, id3v2Frame(3, "TIT2", latin1Text("Long title from ID3v2"))), tags)
+	parseID3v1(id3v1Tag("Short title", "Artist", "", "", "", 0, 0xFF), tags)
+	want := Tags{"tags": "ID3v2.3+ID3v1", "title": "Long title from ID3v2", "artist": "Artist"}
+	if !maps.Equal(tags, want) {
+		t.Errorf("got %v, want %v", tags, want)
+	}
+}
@@ -0,0 +1,173 @@
+package hasher
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// textExtensions name plain text, data and source files, for
+// ClassifyExtension.
+var textExtensions = map[string]bool{
+	".txt": true, ".text": true, ".md": true, ".rst": true, ".log": true,
+	".csv": true, ".tsv": true, ".json": true, ".xml": true, ".yaml": true,
+	".yml": true, ".toml": true, ".ini": true, ".cfg": true, ".conf": true,
+	".html": true, ".htm": true, ".css": true, ".svg": true, ".sql": true,
+	".go": true, ".c": true, ".h": true, ".cpp": true, ".hpp": true,
+	".cs": true, ".java": true, ".kt": true, ".js": true, ".ts": true,
+	".py": true, ".rb": true, ".php": true, ".pl": true, ".rs": true,
+	".swift": true, ".sh": true, ".bat": true, ".ps1": true,
+}
+
+func isText(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	return textExtensions[ext]
+}
+
+// collapsedPrefix marks text hashes computed with blank lines collapsed,
+// which never match ones computed without.
+const collapsedPrefix = "collapsed:"
+
+// maxTextLine bounds the length of a line. Files with longer lines are
+// hardly text, and are compared byte for byte.
+const maxTextLine = 1 << 20
+
+// hashText sets h.Text to a hash of the canonical form of the text r
+// reads: UTF-8 without a byte order mark, lines ending in LF, the last
+// one included, without trailing whitespace, and with
+// Options.CollapseBlankLines no more than one blank line in a row. Text
+// that is not valid UTF-8 is read as Latin-1, and text with a UTF-16 byte
+// order mark as UTF-16.
+func hashText(ctx context.Context, h *HashedFile, r io.Reader, opts Options) error {
+	reader := bufio.NewReaderSize(r, readBufferSize)
+	bom, _ := reader.Peek(3)
+	switch {
+	case bytes.HasPrefix(bom, []byte{0xEF, 0xBB, 0xBF}):
+		_, _ = reader.Discard(3)
+		r = reader
+	case bytes.HasPrefix(bom, []byte{0xFF, 0xFE}), bytes.HasPrefix(bom, []byte{0xFE, 0xFF}):
+		r = &utf16Reader{r: reader, bigEndian: bom[0] == 0xFE}
+	default:
+		r = reader
+	}
+
+	algorithm := opts.hasher()
+	hasher := algorithm.New()
+	out := bufio.NewWriterSize(hasher, readBufferSize)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, readBufferSize), maxTextLine)
+	scanner.Split(scanTextLines)
+
+	blank := false
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if !utf8.Valid(line) {
+			line = []byte(latin1(line))
+		}
+		line = bytes.TrimRightFunc(line, unicode.IsSpace)
+		if opts.CollapseBlankLines && blank && len(line) == 0 {
+			continue
+		}
+		blank = len(line) == 0
+		_, _ = out.Write(line)
+		_ = out.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err == bufio.ErrTooLong {
+			return errNotMedia
+		}
+		return err
+	}
+	_ = out.Flush()
+
+	h.Text = algorithm.Name() + ":" + hex.EncodeToString(hasher.Sum(nil))
+	if opts.CollapseBlankLines {
+		h.Text = collapsedPrefix + h.Text
+	}
+	return nil
+}
+
+// scanTextLines splits lines ending in LF, CRLF or a lone CR, as written
+// by Unix, Windows and classic Mac OS.
+func scanTextLines(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\r' {
+			if i+1 == len(data) && !atEOF {
+				// An LF may follow.
+				return 0, nil, nil
+			}
+			if i+1 < len(data) && data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+		}
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// utf16Reader turns UTF-16 text, starting with its byte order mark, into
+// UTF-8.
+type utf16Reader struct {
+	r         *bufio.Reader
+	bigEndian bool
+	started   bool
+	pending   []byte
+}
+
+func (u *utf16Reader) Read(p []byte) (int, error) {
+	for len(u.pending) < len(p) {
+		unit, err := u.unit()
+		if err != nil {
+			if len(u.pending) > 0 {
+				break
+			}
+			return 0, err
+		}
+		if !u.started {
+			// The byte order mark.
+			u.started = true
+			continue
+		}
+
+		r := rune(unit)
+		if utf16.IsSurrogate(r) {
+			low, err := u.unit()
+			if err != nil {
+				return 0, err
+			}
+			r = utf16.DecodeRune(r, rune(low))
+		}
+		u.pending = utf8.AppendRune(u.pending, r)
+	}
+
+	n := copy(p, u.pending)
+	u.pending = u.pending[n:]
+	return n, nil
+}
+
+func (u *utf16Reader) unit() (uint16, error) {
+	var b [2]byte
+	if _, err := io.ReadFull(u.r, b[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return 0, err
+	}
+	if u.bigEndian {
+		return uint16(b[0])<<8 | uint16(b[1]), nil
+	}
+	return uint16(b[1])<<8 | uint16(b[0]), nil
+}
@@ -0,0 +1,98 @@
+package hasher
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// utf16File encodes s as UTF-16 with a byte order mark.
+func utf16File(s string, order binary.AppendByteOrder) []byte {
+	data := order.AppendUint16(nil, 0xFEFF)
+	for _, u := range utf16.Encode([]rune(s)) {
+		data = order.AppendUint16(data, u)
+	}
+	return data
+}
+
+func TestHashText(t *testing.T) {
+	const text = "Grüße 😀\n\tindented\n\n\n\nlast line\n"
+	tests := []struct {
+		name     string
+		input    []byte
+		collapse bool
+		// want is the canonical form the input must hash as.
+		want string
+	}{
+		{"LF", []byte(text), false, text},
+		{"CRLF", []byte(strings.ReplaceAll(text, "\n", "\r\n")), false, text},
+		{"CR", []byte(strings.ReplaceAll(text, "\n", "\r")), false, text},
+		{"mixed line endings", []byte("Grüße 😀\r\n\tindented\r\r\n\n\nlast line"), false, text},
+		{"no final line ending", []byte(strings.TrimSuffix(text, "\n")), false, text},
+		{"UTF-8 BOM", append([]byte{0xEF, 0xBB, 0xBF}, text...), false, text},
+		{"UTF-16LE", utf16File(text, binary.LittleEndian), false, text},
+		{"UTF-16BE with CRLF", utf16File(strings.ReplaceAll(text, "\n", "\r\n"), binary.BigEndian), false, text},
+		{"Latin-1", []byte("Gr\xfc\xdfe\r\n"), false, "Grüße\n"},
+		{"trailing whitespace", []byte("Grüße 😀  \t\n\tindented \n \n\t\n\v\nlast line \r\n"), false, text},
+		{"leading whitespace kept", []byte("  Grüße\n"), false, "  Grüße\n"},
+		{"blank lines kept", []byte("a\n\n\nb\n"), false, "a\n\n\nb\n"},
+		{"blank lines collapsed", []byte(text), true, "Grüße 😀\n\tindented\n\nlast line\n"},
+		{"whitespace lines collapsed", []byte("a\r\n \r\n\t\r\n\r\nb"), true, "a\n\nb\n"},
+		{"empty", nil, false, ""},
+	}
+
+	for _, tt := range tests {
+		var h HashedFile
+		opts := Options{TextNormalize: true, CollapseBlankLines: tt.collapse}
+		if err := hashText(context.Background(), &h, bytes.NewReader(tt.input), opts); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		sum := sha256.Sum256([]byte(tt.want))
+		want := "sha256:" + hex.EncodeToString(sum[:])
+		if tt.collapse {
+			want = collapsedPrefix + want
+		}
+		if h.Text != want {
+			t.Errorf("%s: got %s, want the hash of %q", tt.name, h.Text, tt.want)
+		}
+	}
+}
+
+func TestHashTextDiffers(t *testing.T) {
+	hash := func(text string, collapse bool) string {
+		var h HashedFile
+		opts := Options{TextNormalize: true, CollapseBlankLines: collapse}
+		if err := hashText(context.Background(), &h, strings.NewReader(text), opts); err != nil {
+			t.Fatal(err)
+		}
+		return h.Text
+	}
+
+	if hash("a\nb\n", false) == hash("a\n\nb\n", false) {
+		t.Error("an extra blank line is ignored without CollapseBlankLines")
+	}
+	if hash("a\nb\n", true) == hash("a\n\nb\n", true) {
+		t.Error("CollapseBlankLines removes a single blank line")
+	}
+	if hash("a\nb\n", false) == hash("a\nb\n", true) {
+		t.Error("hashes with and without CollapseBlankLines are comparable")
+	}
+	if hash("a b\n", false) == hash("a  b\n", false) {
+		t.Error("whitespace inside a line is ignored")
+	}
+}
+
+func TestHashTextLongLine(t *testing.T) {
+	var h HashedFile
+	long := strings.NewReader(strings.Repeat("x", maxTextLine+1))
+	if err := hashText(context.Background(), &h, long, Options{TextNormalize: true}); !errors.Is(err, errNotMedia) {
+		t.Errorf("got %v for an overlong line, want %v", err, errNotMedia)
+	}
+}
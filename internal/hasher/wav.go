@@ -0,0 +1,153 @@
+package hasher
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+)
+
+// WAVE format tags.
+const (
+	wavePCM        = 1
+	waveFloat      = 3
+	waveExtensible = 0xFFFE
+)
+
+// fingerprintWAV decodes the RIFF WAVE file in [start, end) and sets
+// h.Fingerprint from it. Integer PCM of 8 to 32 bits and 32- or 64-bit
+// float samples are understood. It returns how many bytes it read through
+// a job reader; decoding stops once maxFingerprintSeconds have been heard.
+func fingerprintWAV(ctx context.Context, h *HashedFile, file *os.File, start, end int64, opts Options) (int64, error) {
+	section := io.NewSectionReader(file, start, end-start)
+	r := bufio.NewReaderSize(newJobReader(ctx, section, opts), readBufferSize)
+	samples, err := decodeWAV(r)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return 0, ctxErr
+		}
+		return 0, errBadAudio
+	}
+
+	read, _ := section.Seek(0, io.SeekCurrent)
+	return read, h.setFingerprint(samples)
+}
+
+// decodeWAV returns the start of a WAVE file's audio mixed down for
+// fingerprinting.
+func decodeWAV(r *bufio.Reader) ([]float32, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if string(header[:4]) != "RIFF" || string(header[8:]) != "WAVE" {
+		return nil, errBadAudio
+	}
+
+	var format, channels, bitsPerSample int
+	var mix *downmixer
+	for {
+		chunk := make([]byte, 8)
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return nil, err
+		}
+		id, size := string(chunk[:4]), int64(binary.LittleEndian.Uint32(chunk[4:]))
+
+		switch id {
+		case "fmt ":
+			if size < 16 || size > 1<<10 {
+				return nil, errBadAudio
+			}
+			fmtChunk := make([]byte, size)
+			if _, err := io.ReadFull(r, fmtChunk); err != nil {
+				return nil, err
+			}
+			format = int(binary.LittleEndian.Uint16(fmtChunk))
+			channels = int(binary.LittleEndian.Uint16(fmtChunk[2:]))
+			mix = newDownmixer(int(binary.LittleEndian.Uint32(fmtChunk[4:])))
+			bitsPerSample = int(binary.LittleEndian.Uint16(fmtChunk[14:]))
+			if format == waveExtensible && size >= 26 {
+				// The format is the start of the subformat GUID.
+				format = int(binary.LittleEndian.Uint16(fmtChunk[24:]))
+			}
+		case "data":
+			if mix == nil {
+				return nil, errBadAudio
+			}
+			err := decodePCM(io.LimitReader(r, size), format, channels, bitsPerSample, mix)
+			return mix.out, err
+		default:
+			if _, err := r.Discard(int(size + size&1)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if size&1 != 0 {
+			if _, err := r.Discard(1); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// decodePCM feeds interleaved samples to mix until it is full or r ends.
+// A partial last sample frame is ignored.
+func decodePCM(r io.Reader, format, channels, bitsPerSample int, mix *downmixer) error {
+	width := bitsPerSample / 8
+	valid := channels > 0 && bitsPerSample%8 == 0
+	switch format {
+	case wavePCM:
+		valid = valid && width >= 1 && width <= 4
+	case waveFloat:
+		valid = valid && (width == 4 || width == 8)
+	default:
+		valid = false
+	}
+	if !valid {
+		return errBadAudio
+	}
+
+	frame := width * channels
+	buf := make([]byte, frame*1024)
+	for {
+		n, err := io.ReadFull(r, buf)
+		for off := 0; off+frame <= n; off += frame {
+			var sum float64
+			for ch := range channels {
+				sum += pcmSample(buf[off+ch*width:off+(ch+1)*width], format)
+			}
+			if !mix.add(sum / float64(channels)) {
+				return nil
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// pcmSample converts one little-endian sample to the range -1 to 1.
+// 8-bit samples are unsigned, wider integer samples signed.
+func pcmSample(b []byte, format int) float64 {
+	if format == waveFloat {
+		if len(b) == 8 {
+			return math.Float64frombits(binary.LittleEndian.Uint64(b))
+		}
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	}
+	if len(b) == 1 {
+		return (float64(b[0]) - 128) / 128
+	}
+
+	var v uint32
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint32(b[i])
+	}
+	shift := 32 - 8*len(b)
+	return float64(int32(v<<shift)) / (1 << 31)
+}
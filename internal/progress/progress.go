@@ -0,0 +1,284 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Mode string
+
+const (
+	// Auto renders a live status line when the output is a terminal and
+	// periodic log lines otherwise.
+	Auto Mode = "auto"
+	Live Mode = "live"
+	Log  Mode = "log"
+	Off  Mode = "off"
+)
+
+var Modes = []Mode{Auto, Live, Log, Off}
+
+const (
+	liveInterval = 200 * time.Millisecond
+	logInterval  = 10 * time.Second
+	maxPathWidth = 48
+)
+
+// Reporter tracks scan and hash progress and prints it from a background
+// goroutine. All methods are safe for concurrent use, and a nil Reporter
+// does nothing.
+type Reporter struct {
+	out      io.Writer
+	live     bool
+	interval time.Duration
+
+	mu        sync.Mutex
+	hashStart time.Time
+	scanned   int64
+	scanDone  bool
+	queued    int64
+	hashed    int64
+	current   string
+	suspended bool
+	drawn     bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New starts a reporter writing to w, or returns nil when mode is Off.
+func New(w *os.File, mode Mode) *Reporter {
+	if mode == Off {
+		return nil
+	}
+	if mode == Auto {
+		mode = Log
+		if isTerminal(w) {
+			mode = Live
+		}
+	}
+
+	r := &Reporter{
+		out:      w,
+		live:     mode == Live,
+		interval: logInterval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if r.live {
+		r.interval = liveInterval
+	}
+
+	go r.loop()
+	return r
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func (r *Reporter) loop() {
+	defer close(r.done)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.mu.Lock()
+			if !r.suspended {
+				r.draw()
+			}
+			r.mu.Unlock()
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// FileScanned counts a file found by the scan.
+func (r *Reporter) FileScanned() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.scanned++
+	r.mu.Unlock()
+}
+
+// ScanDone marks the scan as complete, so the byte total is final and an
+// ETA can be given.
+func (r *Reporter) ScanDone() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.scanDone = true
+	r.mu.Unlock()
+}
+
+// Queued adds bytes that will be read for hashing.
+func (r *Reporter) Queued(bytes int64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.queued += bytes
+	r.mu.Unlock()
+}
+
+// Start records the file currently being hashed.
+func (r *Reporter) Start(path string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.current = path
+	if r.hashStart.IsZero() {
+		r.hashStart = time.Now()
+	}
+	r.mu.Unlock()
+}
+
+// Read counts bytes read for hashing.
+func (r *Reporter) Read(n int64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.hashed += n
+	r.mu.Unlock()
+}
+
+// Suspend clears the status line and stops drawing until Resume, so other
+// output and prompts are not overwritten.
+func (r *Reporter) Suspend() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.suspended = true
+	r.clear()
+	r.mu.Unlock()
+}
+
+func (r *Reporter) Resume() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.suspended = false
+	r.mu.Unlock()
+}
+
+// Printf writes a message to the reporter's output, clearing the status
+// line first so the message is not overwritten. The line is drawn again at
+// the next update. A nil Reporter writes to standard error.
+func (r *Reporter) Printf(format string, args ...any) {
+	if r == nil {
+		fmt.Fprintf(os.Stderr, format, args...)
+		return
+	}
+	r.mu.Lock()
+	r.clear()
+	fmt.Fprintf(r.out, format, args...)
+	r.mu.Unlock()
+}
+
+// Stop ends reporting and clears the status line.
+func (r *Reporter) Stop() {
+	if r == nil {
+		return
+	}
+	close(r.stop)
+	<-r.done
+	r.Suspend()
+}
+
+func (r *Reporter) clear() {
+	if r.live && r.drawn {
+		fmt.Fprint(r.out, "\r\033[K")
+		r.drawn = false
+	}
+}
+
+func (r *Reporter) draw() {
+	line := r.status(time.Now())
+	if r.live {
+		fmt.Fprintf(r.out, "\r\033[K%s", line)
+		r.drawn = true
+	} else {
+		fmt.Fprintln(r.out, line)
+	}
+}
+
+func (r *Reporter) status(now time.Time) string {
+	parts := []string{fmt.Sprintf("Scanned %d files", r.scanned)}
+
+	if r.queued > 0 {
+		hashed := fmt.Sprintf("Hashed %s / %s", formatBytes(r.hashed), formatBytes(r.queued))
+		if r.scanDone {
+			hashed += fmt.Sprintf(" (%d%%)", r.hashed*100/r.queued)
+		}
+		parts = append(parts, hashed)
+	}
+
+	if !r.hashStart.IsZero() {
+		elapsed := now.Sub(r.hashStart).Seconds()
+		if elapsed > 0 {
+			rate := float64(r.hashed) / elapsed
+			parts = append(parts, formatBytes(int64(rate))+"/s")
+
+			eta := "ETA --"
+			if r.scanDone && rate > 0 {
+				remaining := time.Duration(float64(r.queued-r.hashed) / rate * float64(time.Second))
+				eta = "ETA " + formatDuration(remaining)
+			}
+			parts = append(parts, eta)
+		}
+	}
+
+	if r.current != "" {
+		parts = append(parts, shorten(r.current, maxPathWidth))
+	}
+
+	return strings.Join(parts, " | ")
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	h := d / time.Hour
+	m := (d % time.Hour) / time.Minute
+	s := (d % time.Minute) / time.Second
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
+
+// shorten keeps the end of a path, which is the most telling part.
+func shorten(path string, width int) string {
+	runes := []rune(path)
+	if len(runes) <= width {
+		return path
+	}
+	return "…" + string(runes[len(runes)-width+1:])
+}
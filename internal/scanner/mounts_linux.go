@@ -0,0 +1,106 @@
+package scanner
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// virtualFilesystems hold kernel state rather than user files and are never
+// descended into.
+var virtualFilesystems = map[string]bool{
+	"autofs":      true,
+	"binfmt_misc": true,
+	"bpf":         true,
+	"cgroup":      true,
+	"cgroup2":     true,
+	"configfs":    true,
+	"debugfs":     true,
+	"devpts":      true,
+	"devtmpfs":    true,
+	"efivarfs":    true,
+	"fusectl":     true,
+	"hugetlbfs":   true,
+	"mqueue":      true,
+	"nsfs":        true,
+	"proc":        true,
+	"pstore":      true,
+	"rpc_pipefs":  true,
+	"securityfs":  true,
+	"selinuxfs":   true,
+	"sysfs":       true,
+	"tracefs":     true,
+}
+
+// readMounts parses /proc/self/mountinfo. Errors yield no mounts, which
+// only disables pruning.
+func readMounts() []mount {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var mounts []mount
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// 36 35 98:0 /mnt1 /mnt/parent rw,noatime master:1 - ext3 /dev/root rw
+		fields := strings.Fields(scanner.Text())
+		sep := -1
+		for i, field := range fields {
+			if field == "-" {
+				sep = i
+				break
+			}
+		}
+		if len(fields) < 5 || sep == -1 || sep+1 >= len(fields) {
+			continue
+		}
+
+		major, minor, ok := strings.Cut(fields[2], ":")
+		if !ok {
+			continue
+		}
+		maj, err1 := strconv.ParseUint(major, 10, 32)
+		min, err2 := strconv.ParseUint(minor, 10, 32)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+
+		mounts = append(mounts, mount{
+			path:    unescapeMountPath(fields[4]),
+			dev:     mkdev(maj, min),
+			fsType:  fields[sep+1],
+			virtual: virtualFilesystems[fields[sep+1]],
+		})
+	}
+
+	return mounts
+}
+
+// mkdev encodes a device number the way the kernel reports it in st_dev.
+func mkdev(major, minor uint64) uint64 {
+	return (major&0xfffff000)<<32 | (major&0xfff)<<8 | (minor&0xffffff00)<<12 | minor&0xff
+}
+
+// unescapeMountPath decodes the octal escapes (\040 for space, ...) used in
+// mountinfo paths.
+func unescapeMountPath(path string) string {
+	if !strings.Contains(path, `\`) {
+		return path
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			if c, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(path[i])
+	}
+	return b.String()
+}
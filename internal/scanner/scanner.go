@@ -1,36 +1,303 @@
 package scanner
 
 import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 )
 
+// FileInfo carries everything the scanner learned about a file from a
+// single lstat, so later stages never need to stat it again. ChangeTime,
+// UID, GID, Dev, Ino and Nlink are zero on platforms that do not report them.
 type FileInfo struct {
-	Path string
-	Size int64
+	Path       string
+	Size       int64
+	ModTime    time.Time
+	ChangeTime time.Time
+	Mode       os.FileMode
+	UID        uint32
+	GID        uint32
+	Dev        uint64
+	Ino        uint64
+	Nlink      uint64
+	Root       string
+	// Link is the symlink target as written in the link, for followed and
+	// reported symlinks.
+	Link string
+	// Skipped marks entries a walk only lists with Options.ListAll: empty
+	// files, symlinks it does not follow and special files. They are
+	// never hashed.
+	Skipped bool
+
+	// viaLink marks entries reached through a followed symlink.
+	viaLink bool
 }
 
-func ScanDirectory(rootPath string) ([]FileInfo, error) {
-	var files []FileInfo
+func newFileInfo(path string, info os.FileInfo) FileInfo {
+	file := FileInfo{
+		Path:    path,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		Mode:    info.Mode(),
+	}
+	fillSysInfo(&file, info)
+	return file
+}
+
+// Type returns the file type bits of Mode, e.g. os.ModeSymlink.
+func (f FileInfo) Type() os.FileMode {
+	return f.Mode.Type()
+}
+
+// SameFile reports whether both entries refer to the same inode, as hard
+// links do. It is always false when the platform reports no inode numbers.
+func (f FileInfo) SameFile(other FileInfo) bool {
+	return f.Ino != 0 && f.Ino == other.Ino && f.Dev == other.Dev
+}
+
+type SymlinkPolicy string
+
+const (
+	// SymlinksSkip ignores symlinks entirely.
+	SymlinksSkip SymlinkPolicy = "skip"
+	// SymlinksFollow scans what symlinks point to, as if the target lived
+	// at the link's path.
+	SymlinksFollow SymlinkPolicy = "follow"
+	// SymlinksReport lists symlinks without following them. The entries
+	// keep the link's own metadata and are never hashed.
+	SymlinksReport SymlinkPolicy = "report"
+)
+
+var SymlinkPolicies = []SymlinkPolicy{SymlinksSkip, SymlinksFollow, SymlinksReport}
+
+type Options struct {
+	Symlinks SymlinkPolicy
+	// OneFileSystem keeps the scan on the root's device and out of any
+	// mount point below the root, including bind mounts.
+	OneFileSystem bool
+	// MaxDepth limits how many levels below the root are listed; 1 means
+	// only the root's own entries. Zero means no limit.
+	MaxDepth int
+	// Workers bounds the number of directories read at the same time.
+	// Zero means DefaultWorkers.
+	Workers int
+	// ListAll also lists the entries a walk otherwise leaves out, marked as
+	// Skipped, so callers can tell everything a directory holds.
+	ListAll bool
+}
+
+// dropRedundantLinks removes entries reached through followed symlinks
+// whose target is listed as well, either directly or through an earlier
+// link. A link must never be offered for deletion against its own target.
+func dropRedundantLinks(files []FileInfo) []FileInfo {
+	if !slices.ContainsFunc(files, func(file FileInfo) bool { return file.viaLink }) {
+		return files
+	}
+
+	targets := make(map[string]bool)
+	for _, file := range files {
+		if !file.viaLink {
+			targets[fileKey(file)] = true
+		}
+	}
 
-	err := filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
+	result := files[:0]
+	for _, file := range files {
+		if file.viaLink {
+			key := fileKey(file)
+			if targets[key] {
+				continue
+			}
+			targets[key] = true
+		}
+		result = append(result, file)
+	}
+
+	return result
+}
+
+// SplitCandidates separates the regular files that can be hashed from the
+// other entries a scan returns: reported symlinks and pruned directories.
+func SplitCandidates(files []FileInfo) ([]FileInfo, []FileInfo) {
+	var regular, other []FileInfo
+	for _, file := range files {
+		if file.Mode.IsRegular() {
+			regular = append(regular, file)
+		} else {
+			other = append(other, file)
+		}
+	}
+	return regular, other
+}
+
+// ScanDirectories scans every root into a single list. Roots that repeat or
+// lie inside another root are dropped first so no file is listed twice.
+func ScanDirectories(ctx context.Context, roots []string, opts Options) ([]FileInfo, []string, error) {
+	roots = DedupeRoots(roots)
+
+	var files []FileInfo
+	for _, root := range roots {
+		found, err := ScanDirectory(ctx, root, opts)
 		if err != nil {
-			return err
+			return nil, nil, err
+		}
+		files = append(files, found...)
+	}
+
+	return dropRedundantLinks(files), roots, nil
+}
+
+// DedupeRoots removes roots that point at the same directory as an earlier
+// root or that are nested inside another root. Order is otherwise kept.
+func DedupeRoots(roots []string) []string {
+	resolved := make([]string, len(roots))
+	for i, root := range roots {
+		resolved[i] = resolvePath(root)
+	}
+
+	var result []string
+	for i, root := range roots {
+		redundant := false
+		for j := range roots {
+			if i == j {
+				continue
+			}
+			if (j < i && sameDirectory(roots[i], roots[j], resolved[i], resolved[j])) || isNested(resolved[i], resolved[j]) {
+				redundant = true
+				break
+			}
+		}
+
+		if !redundant {
+			result = append(result, root)
+		}
+	}
+
+	return result
+}
+
+func resolvePath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	if real, err := filepath.EvalSymlinks(abs); err == nil {
+		return real
+	}
+	return abs
+}
+
+func sameDirectory(path1, path2, resolved1, resolved2 string) bool {
+	if resolved1 == resolved2 {
+		return true
+	}
+
+	info1, err1 := os.Stat(path1)
+	info2, err2 := os.Stat(path2)
+	return err1 == nil && err2 == nil && os.SameFile(info1, info2)
+}
+
+func isNested(path, parent string) bool {
+	rel, err := filepath.Rel(parent, path)
+	if err != nil || rel == "." {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator)) && !filepath.IsAbs(rel)
+}
+
+// ReadFileList builds FileInfo entries from a list of paths, one per line or
+// NUL-separated when nul is set, instead of walking a directory. Symlinks
+// are handled like in ScanDirectory. Entries that cannot be used (missing,
+// not regular files, empty) are returned as skipped errors rather than
+// failing the whole list.
+func ReadFileList(r io.Reader, nul bool, opts Options) ([]FileInfo, []error, error) {
+	sep := byte('\n')
+	if nul {
+		sep = 0
+	}
+
+	var files []FileInfo
+	var skipped []error
+	seen := make(map[string]bool)
+
+	reader := bufio.NewReader(r)
+	for {
+		entry, readErr := reader.ReadString(sep)
+		if readErr != nil && readErr != io.EOF {
+			return nil, nil, readErr
+		}
+
+		entry = strings.TrimSuffix(entry, string(sep))
+		if !nul {
+			entry = strings.TrimSuffix(entry, "\r")
 		}
 
-		if !info.IsDir() && info.Size() > 0 {
-			files = append(files, FileInfo{
-				Path: path,
-				Size: info.Size(),
-			})
+		if key := listKey(entry); entry != "" && !seen[key] {
+			seen[key] = true
+			if file, err := statFile(entry, opts); err != nil {
+				skipped = append(skipped, err)
+			} else if file.Size > 0 {
+				files = append(files, file)
+			}
 		}
 
-		return nil
-	})
+		if readErr == io.EOF {
+			break
+		}
+	}
 
+	return dropRedundantLinks(files), skipped, nil
+}
+
+// listKey identifies a file list entry, so "a.txt", "./a.txt" and paths
+// through a symlinked directory are listed once. Only the parent directory
+// is resolved; a symlink entry is handled by opts.Symlinks.
+func listKey(path string) string {
+	abs, err := filepath.Abs(path)
 	if err != nil {
-		return nil, err
+		return filepath.Clean(path)
+	}
+	dir := filepath.Dir(abs)
+	if real, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = real
+	}
+	return filepath.Join(dir, filepath.Base(abs))
+}
+
+func statFile(path string, opts Options) (FileInfo, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	file := newFileInfo(path, info)
+
+	if file.Type() == os.ModeSymlink {
+		switch opts.Symlinks {
+		case SymlinksFollow:
+			target, err := os.Stat(path)
+			if err != nil {
+				return FileInfo{}, err
+			}
+			file = newFileInfo(path, target)
+			file.Link, _ = os.Readlink(path)
+			file.viaLink = true
+		case SymlinksReport:
+			file.Link, _ = os.Readlink(path)
+			return file, nil
+		default:
+			return FileInfo{}, &os.PathError{Op: "lstat", Path: path, Err: errors.New("is a symlink")}
+		}
+	}
+
+	if !file.Mode.IsRegular() {
+		return FileInfo{}, &os.PathError{Op: "lstat", Path: path, Err: errors.New("not a regular file")}
 	}
 
-	return files, nil
+	return file, nil
 }
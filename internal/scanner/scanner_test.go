@@ -0,0 +1,201 @@
+//go:build linux || darwin
+
+package scanner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// scanFixture builds a directory with a file, a hard link to it, another
+// file and a symlink, and scans it with symlinks reported.
+func scanFixture(t *testing.T) (string, map[string]FileInfo) {
+	t.Helper()
+	root := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(root, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	original := write("original.txt", "linked content")
+	if err := os.Link(original, filepath.Join(root, "hardlink.txt")); err != nil {
+		t.Fatal(err)
+	}
+	other := write("other.txt", "other content")
+	if err := os.Chmod(other, 0o640); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2020, 5, 17, 12, 30, 0, 0, time.UTC)
+	if err := os.Chtimes(other, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("other.txt", filepath.Join(root, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := ScanDirectory(context.Background(), root, Options{Symlinks: SymlinksReport})
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]FileInfo)
+	for _, file := range files {
+		byName[filepath.Base(file.Path)] = file
+	}
+	if len(byName) != 4 {
+		t.Fatalf("scanned %d entries, want 4: %v", len(byName), files)
+	}
+	return root, byName
+}
+
+func TestScanFillsMetadata(t *testing.T) {
+	root, files := scanFixture(t)
+
+	file := files["other.txt"]
+	if !file.Mode.IsRegular() || file.Mode.Perm() != 0o640 {
+		t.Errorf("mode is %v, want a regular file with 0640", file.Mode)
+	}
+	if want := time.Date(2020, 5, 17, 12, 30, 0, 0, time.UTC); !file.ModTime.Equal(want) {
+		t.Errorf("mtime is %v, want %v", file.ModTime, want)
+	}
+	if file.Size != int64(len("other content")) {
+		t.Errorf("size is %d", file.Size)
+	}
+	if file.Root != root {
+		t.Errorf("root is %q, want %q", file.Root, root)
+	}
+	if file.ChangeTime.IsZero() {
+		t.Error("change time is not set")
+	}
+
+	info, err := os.Lstat(file.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := info.Sys().(*syscall.Stat_t)
+	if file.UID != st.Uid || file.GID != st.Gid {
+		t.Errorf("owner is %d:%d, want %d:%d", file.UID, file.GID, st.Uid, st.Gid)
+	}
+	if file.UID != uint32(os.Getuid()) {
+		t.Errorf("UID is %d, want %d", file.UID, os.Getuid())
+	}
+	if file.Dev != uint64(st.Dev) || file.Ino != st.Ino || file.Ino == 0 {
+		t.Errorf("device and inode are %d/%d, want %d/%d", file.Dev, file.Ino, st.Dev, st.Ino)
+	}
+	if file.Nlink != 1 {
+		t.Errorf("link count is %d, want 1", file.Nlink)
+	}
+}
+
+func TestScanHardLinks(t *testing.T) {
+	_, files := scanFixture(t)
+
+	original, link := files["original.txt"], files["hardlink.txt"]
+	if original.Nlink != 2 || link.Nlink != 2 {
+		t.Errorf("link counts are %d and %d, want 2", original.Nlink, link.Nlink)
+	}
+	if !original.SameFile(link) {
+		t.Error("hard links are not reported as the same file")
+	}
+	if original.SameFile(files["other.txt"]) {
+		t.Error("different files are reported as the same file")
+	}
+	if (FileInfo{}).SameFile(FileInfo{}) {
+		t.Error("entries without inode numbers are reported as the same file")
+	}
+}
+
+func TestScanReportsSymlinks(t *testing.T) {
+	_, files := scanFixture(t)
+
+	link := files["link.txt"]
+	if link.Type() != os.ModeSymlink {
+		t.Errorf("symlink has type %v", link.Type())
+	}
+	if link.Mode.IsRegular() {
+		t.Error("symlink is listed as a regular file")
+	}
+	if link.Link != "other.txt" {
+		t.Errorf("symlink target is %q, want other.txt", link.Link)
+	}
+	if link.SameFile(files["other.txt"]) {
+		t.Error("symlink is reported as the same file as its target")
+	}
+}
+
+func TestScanListAll(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "data.txt"), []byte("data"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "empty"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("data.txt", filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Mkfifo(filepath.Join(root, "fifo"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := ScanDirectory(context.Background(), root, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || filepath.Base(files[0].Path) != "data.txt" {
+		t.Fatalf("default scan listed %v, want only data.txt", files)
+	}
+
+	files, err = ScanDirectory(context.Background(), root, Options{ListAll: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]os.FileMode{"data.txt": 0, "empty": 0, "fifo": os.ModeNamedPipe, "link": os.ModeSymlink}
+	if len(files) != len(want) {
+		t.Fatalf("listed %d entries, want %d: %v", len(files), len(want), files)
+	}
+	for _, file := range files {
+		name := filepath.Base(file.Path)
+		typ, ok := want[name]
+		if !ok || file.Type() != typ {
+			t.Errorf("%s has type %v", name, file.Type())
+		}
+		if file.Skipped != (name != "data.txt") {
+			t.Errorf("%s: Skipped is %v", name, file.Skipped)
+		}
+	}
+}
+
+func TestReadFileListDedupes(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "sub"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(root, filepath.Join(root, "alias")); err != nil {
+		t.Fatal(err)
+	}
+
+	list := strings.Join([]string{
+		filepath.Join(root, "a.txt"),
+		root + "/./a.txt",
+		root + "/sub/../a.txt",
+		filepath.Join(root, "alias", "a.txt"),
+	}, "\n")
+	files, skipped, err := ReadFileList(strings.NewReader(list), false, Options{})
+	if err != nil || len(skipped) > 0 {
+		t.Fatal(err, skipped)
+	}
+	if len(files) != 1 {
+		t.Errorf("listed %d files, want 1: %v", len(files), files)
+	}
+}
@@ -0,0 +1,21 @@
+package scanner
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+func fillSysInfo(file *FileInfo, info os.FileInfo) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+
+	file.ChangeTime = time.Unix(int64(st.Ctim.Sec), int64(st.Ctim.Nsec))
+	file.UID = st.Uid
+	file.GID = st.Gid
+	file.Dev = uint64(st.Dev)
+	file.Ino = st.Ino
+	file.Nlink = uint64(st.Nlink)
+}
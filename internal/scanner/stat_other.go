@@ -0,0 +1,7 @@
+//go:build !linux && !darwin
+
+package scanner
+
+import "os"
+
+func fillSysInfo(file *FileInfo, info os.FileInfo) {}
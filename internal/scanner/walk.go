@@ -0,0 +1,469 @@
+package scanner
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"iter"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// DefaultWorkers is the number of directories read concurrently when
+// Options.Workers is zero. Directory reads mostly wait on the file system,
+// so this is well above the CPU count to hide network latency.
+const DefaultWorkers = 16
+
+type mount struct {
+	path    string
+	dev     uint64
+	fsType  string
+	virtual bool
+}
+
+var (
+	mountsOnce sync.Once
+	mounts     []mount
+)
+
+type dirJob struct {
+	path    string
+	absPath string
+	depth   int
+	// ancestors identify the directories above this one, to stop symlink
+	// loops. Only tracked when following symlinks.
+	ancestors []string
+	viaLink   bool
+}
+
+type walker struct {
+	ctx          context.Context
+	cancel       context.CancelFunc
+	opts         Options
+	root         string
+	rootDev      uint64
+	virtualPaths map[string]bool
+	virtualDevs  map[uint64]bool
+	mountPoints  map[string]bool
+
+	out chan<- []FileInfo
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []dirJob
+	active int
+	err    error
+}
+
+// ScanDirectory lists the non-empty regular files below rootPath. The root
+// itself is always resolved if it is a symlink; links found while walking
+// are handled according to opts.Symlinks.
+//
+// Directories are read concurrently, but the result is always sorted by
+// path in walk order. Virtual filesystems such as /proc and /sys are never
+// entered below the root. Directories left out for that reason, by
+// opts.OneFileSystem or by opts.MaxDepth are returned as directory entries
+// so callers can tell a partial scan from a complete one. With
+// opts.ListAll, the rest of each directory is listed too, marked Skipped.
+func ScanDirectory(ctx context.Context, rootPath string, opts Options) ([]FileInfo, error) {
+	var files []FileInfo
+	for file, err := range Walk(ctx, []string{rootPath}, opts) {
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+
+	slices.SortFunc(files, func(a, b FileInfo) int {
+		return ComparePaths(a.Path, b.Path)
+	})
+
+	return files, nil
+}
+
+// Walk streams the same entries as ScanDirectory for each root while the
+// directories are still being read, in no particular order. Roots are
+// deduplicated like in ScanDirectories. Entries reached through followed
+// symlinks are held back until the end, so links to files that were listed
+// anyway can be dropped. A walk error is yielded last and ends the sequence.
+func Walk(ctx context.Context, roots []string, opts Options) iter.Seq2[FileInfo, error] {
+	return func(yield func(FileInfo, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		var links []FileInfo
+		targets := make(map[string]bool)
+
+		for _, root := range DedupeRoots(roots) {
+			out := make(chan []FileInfo, DefaultWorkers)
+			var walkErr error
+			go func() {
+				walkErr = walkRoot(ctx, root, opts, out)
+				close(out)
+			}()
+
+			for batch := range out {
+				for _, file := range batch {
+					if file.viaLink {
+						links = append(links, file)
+						continue
+					}
+					if opts.Symlinks == SymlinksFollow {
+						targets[fileKey(file)] = true
+					}
+					if !yield(file, nil) {
+						cancel()
+						for range out {
+						}
+						return
+					}
+				}
+			}
+
+			if walkErr != nil {
+				yield(FileInfo{}, walkErr)
+				return
+			}
+		}
+
+		slices.SortFunc(links, func(a, b FileInfo) int {
+			return ComparePaths(a.Path, b.Path)
+		})
+		for _, link := range links {
+			key := fileKey(link)
+			if targets[key] {
+				continue
+			}
+			targets[key] = true
+			if !yield(link, nil) {
+				return
+			}
+		}
+	}
+}
+
+func walkRoot(ctx context.Context, rootPath string, opts Options, out chan<- []FileInfo) error {
+	info, err := os.Stat(rootPath)
+	if err != nil {
+		return err
+	}
+	root := newFileInfo(rootPath, info)
+	root.Root = rootPath
+
+	if !info.IsDir() {
+		select {
+		case out <- []FileInfo{root}:
+		case <-ctx.Done():
+		}
+		return ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w := &walker{
+		ctx:          ctx,
+		cancel:       cancel,
+		opts:         opts,
+		root:         rootPath,
+		rootDev:      root.Dev,
+		virtualPaths: make(map[string]bool),
+		virtualDevs:  make(map[uint64]bool),
+		mountPoints:  make(map[string]bool),
+		out:          out,
+	}
+	w.cond = sync.NewCond(&w.mu)
+
+	mountsOnce.Do(func() { mounts = readMounts() })
+	for _, m := range mounts {
+		if m.virtual {
+			w.virtualPaths[m.path] = true
+			w.virtualDevs[m.dev] = true
+		}
+		if opts.OneFileSystem {
+			w.mountPoints[m.path] = true
+		}
+	}
+
+	job := dirJob{path: rootPath, absPath: resolvePath(rootPath)}
+	if opts.Symlinks == SymlinksFollow {
+		job.ancestors = []string{fileKey(root)}
+	}
+	w.queue = append(w.queue, job)
+
+	stop := context.AfterFunc(ctx, func() {
+		w.mu.Lock()
+		w.cond.Broadcast()
+		w.mu.Unlock()
+	})
+	defer stop()
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				job, ok := w.next()
+				if !ok {
+					return
+				}
+				w.readDir(job)
+				w.done()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if w.err != nil {
+		return w.err
+	}
+	return ctx.Err()
+}
+
+// next blocks until a directory is queued or the walk is over.
+func (w *walker) next() (dirJob, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for len(w.queue) == 0 && w.active > 0 && w.ctx.Err() == nil {
+		w.cond.Wait()
+	}
+
+	if len(w.queue) == 0 || w.ctx.Err() != nil {
+		w.cond.Broadcast()
+		return dirJob{}, false
+	}
+
+	job := w.queue[len(w.queue)-1]
+	w.queue = w.queue[:len(w.queue)-1]
+	w.active++
+	return job, true
+}
+
+func (w *walker) done() {
+	w.mu.Lock()
+	w.active--
+	w.cond.Broadcast()
+	w.mu.Unlock()
+}
+
+func (w *walker) fail(err error) {
+	w.mu.Lock()
+	if w.err == nil {
+		w.err = err
+	}
+	w.mu.Unlock()
+	w.cancel()
+}
+
+func (w *walker) readDir(job dirJob) {
+	entries, err := os.ReadDir(job.path)
+	if err != nil {
+		w.fail(err)
+		return
+	}
+
+	var found []FileInfo
+	var subdirs []dirJob
+	for _, entry := range entries {
+		if w.ctx.Err() != nil {
+			return
+		}
+
+		path := filepath.Join(job.path, entry.Name())
+		absPath := filepath.Join(job.absPath, entry.Name())
+		typ := entry.Type()
+		var file FileInfo
+
+		if typ == os.ModeSymlink {
+			switch w.opts.Symlinks {
+			case SymlinksFollow:
+				target, err := os.Stat(path)
+				if err != nil {
+					// Dangling or looping link: nothing to scan.
+					found = w.appendSkipped(found, job, path)
+					continue
+				}
+				file = newFileInfo(path, target)
+				file.Link, _ = os.Readlink(path)
+				file.viaLink = true
+				typ = target.Mode().Type()
+			case SymlinksReport:
+				info, err := entry.Info()
+				if err != nil {
+					continue
+				}
+				file = newFileInfo(path, info)
+				file.Link, _ = os.Readlink(path)
+				found = append(found, file)
+				continue
+			default:
+				found = w.appendSkipped(found, job, path)
+				continue
+			}
+		}
+
+		switch {
+		case typ.IsDir():
+			if sub, pruned, ok := w.enterDir(job, path, absPath, file); ok {
+				subdirs = append(subdirs, sub)
+			} else if pruned != nil {
+				found = append(found, *pruned)
+			} else {
+				// A link closing a loop.
+				found = w.appendSkipped(found, job, path)
+			}
+
+		case typ.IsRegular():
+			if file.Path == "" {
+				info, err := entry.Info()
+				if os.IsNotExist(err) {
+					continue
+				}
+				if err != nil {
+					w.fail(err)
+					return
+				}
+				file = newFileInfo(path, info)
+				file.viaLink = job.viaLink
+			}
+			if file.Size > 0 {
+				found = append(found, file)
+			} else if w.opts.ListAll {
+				file.Skipped = true
+				found = append(found, file)
+			}
+
+		default:
+			found = w.appendSkipped(found, job, path)
+		}
+	}
+
+	w.mu.Lock()
+	w.queue = append(w.queue, subdirs...)
+	w.cond.Broadcast()
+	w.mu.Unlock()
+
+	if len(found) == 0 {
+		return
+	}
+	for i := range found {
+		found[i].Root = w.root
+	}
+	select {
+	case w.out <- found:
+	case <-w.ctx.Done():
+	}
+}
+
+// appendSkipped adds an entry the walk leaves out to found when
+// Options.ListAll asks for it, with its own metadata.
+func (w *walker) appendSkipped(found []FileInfo, job dirJob, path string) []FileInfo {
+	if !w.opts.ListAll {
+		return found
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return found
+	}
+	file := newFileInfo(path, info)
+	if info.Mode().Type() == os.ModeSymlink {
+		file.Link, _ = os.Readlink(path)
+	}
+	file.Skipped = true
+	file.viaLink = job.viaLink
+	return append(found, file)
+}
+
+// enterDir decides what to do with a subdirectory: queue it, report it as
+// pruned, or drop it because it closes a symlink loop. file is only set
+// when the directory was reached through a followed symlink.
+func (w *walker) enterDir(parent dirJob, path, absPath string, file FileInfo) (dirJob, *FileInfo, bool) {
+	depth := parent.depth + 1
+	viaLink := parent.viaLink || file.viaLink
+	follow := w.opts.Symlinks == SymlinksFollow
+
+	// Stat only when something depends on it; a plain walk gets by with
+	// the type from the directory entry.
+	if file.Path == "" && (follow || w.opts.OneFileSystem) {
+		info, err := os.Lstat(path)
+		if err != nil {
+			return dirJob{}, nil, false
+		}
+		file = newFileInfo(path, info)
+	}
+	if file.Path == "" {
+		file = FileInfo{Path: path, Mode: os.ModeDir}
+	}
+
+	if w.prune(file, absPath, depth) {
+		return dirJob{}, &file, false
+	}
+
+	job := dirJob{path: path, absPath: absPath, depth: depth, viaLink: viaLink}
+	if follow {
+		key := fileKey(file)
+		if slices.Contains(parent.ancestors, key) {
+			return dirJob{}, nil, false
+		}
+		job.ancestors = append(slices.Clip(parent.ancestors), key)
+	}
+
+	return job, nil, true
+}
+
+// prune reports whether the directory at the given depth below the root
+// must be left out.
+func (w *walker) prune(dir FileInfo, absPath string, depth int) bool {
+	if w.opts.MaxDepth > 0 && depth >= w.opts.MaxDepth {
+		return true
+	}
+
+	if w.virtualPaths[absPath] || (dir.Ino != 0 && w.virtualDevs[dir.Dev]) {
+		return true
+	}
+
+	if w.opts.OneFileSystem && (dir.Dev != w.rootDev || w.mountPoints[absPath]) {
+		return true
+	}
+
+	return false
+}
+
+// fileKey identifies a file or directory across different paths to it.
+// Platforms without inode numbers fall back to the fully resolved path.
+func fileKey(file FileInfo) string {
+	if file.Ino != 0 {
+		return fmt.Sprintf("%d:%d", file.Dev, file.Ino)
+	}
+	return resolvePath(file.Path)
+}
+
+// ComparePaths orders paths the way a depth-first walk visits them: the
+// separator sorts before any other character, so "a/b" comes before "a.txt".
+func ComparePaths(a, b string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		ca, cb := a[i], b[i]
+		switch {
+		case ca == cb:
+			continue
+		case ca == os.PathSeparator:
+			return -1
+		case cb == os.PathSeparator:
+			return 1
+		case ca < cb:
+			return -1
+		default:
+			return 1
+		}
+	}
+	return cmp.Compare(len(a), len(b))
+}
@@ -0,0 +1,113 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// writeTree creates the given files, with their parent directories, below
+// root.
+func writeTree(t *testing.T, root string, names []string) {
+	t.Helper()
+	for _, name := range names {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestScanDirectoryOrder(t *testing.T) {
+	// Listed in walk order: a directory's contents come before names that
+	// only extend its name, such as "a.txt" after "a/".
+	names := []string{
+		"a/b/c.txt",
+		"a/b.txt",
+		"a/z.txt",
+		"a-b/x.txt",
+		"a.txt",
+		"ab.txt",
+		"b/a.txt",
+		"b/c/d/e.txt",
+		"b.txt",
+	}
+	root := t.TempDir()
+	writeTree(t, root, names)
+
+	var want []string
+	for _, name := range names {
+		want = append(want, filepath.Join(root, filepath.FromSlash(name)))
+	}
+
+	for range 20 {
+		files, err := ScanDirectory(context.Background(), root, Options{Workers: 8})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, file := range files {
+			got = append(got, file.Path)
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("scanned\n%q\nwant\n%q", got, want)
+		}
+	}
+}
+
+func TestWalkCancel(t *testing.T) {
+	root := t.TempDir()
+	var names []string
+	for i := range 50 {
+		for j := range 20 {
+			names = append(names, fmt.Sprintf("d%d/f%d", i, j))
+		}
+	}
+	writeTree(t, root, names)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	seen := 0
+	var walkErr error
+	for _, err := range Walk(ctx, []string{root}, Options{Workers: 4}) {
+		if err != nil {
+			walkErr = err
+			break
+		}
+		seen++
+		cancel()
+	}
+	if !errors.Is(walkErr, context.Canceled) {
+		t.Errorf("walk ended with %v, want %v", walkErr, context.Canceled)
+	}
+	if seen == 0 || seen >= len(names) {
+		t.Errorf("walk yielded %d of %d files after being cancelled", seen, len(names))
+	}
+
+	if _, err := ScanDirectory(ctx, root, Options{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("scan with a cancelled context returned %v", err)
+	}
+}
+
+func TestWalkStopsEarly(t *testing.T) {
+	root := t.TempDir()
+	var names []string
+	for i := range 200 {
+		names = append(names, fmt.Sprintf("d%d/f", i))
+	}
+	writeTree(t, root, names)
+
+	// Breaking out of the loop must stop the workers instead of leaving
+	// them blocked on a full channel.
+	for range Walk(context.Background(), []string{root}, Options{Workers: 2}) {
+		break
+	}
+}
@@ -0,0 +1,44 @@
+package throttle
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+)
+
+const (
+	ioprioWhoProcess = 1
+	ioprioClassIdle  = 3
+	ioprioClassShift = 13
+)
+
+// SetIdlePriority puts the process in the idle IO scheduling class, so it
+// only gets disk time no other process wants. The priority is per thread on
+// Linux, so it is set on every existing thread; threads started later
+// inherit it from the thread that creates them.
+func SetIdlePriority() error {
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return setIdlePriority(0)
+	}
+
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		if err := setIdlePriority(tid); err != nil && err != syscall.ESRCH {
+			return err
+		}
+	}
+	return nil
+}
+
+func setIdlePriority(tid int) error {
+	prio := ioprioClassIdle << ioprioClassShift
+	_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(prio))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package throttle
+
+import "errors"
+
+// SetIdlePriority is only supported on Linux.
+func SetIdlePriority() error {
+	return errors.New("idle IO priority is only supported on Linux")
+}
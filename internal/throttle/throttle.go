@@ -0,0 +1,86 @@
+package throttle
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Throttle limits how fast files are read, in bytes and in read operations
+// per second. A nil Throttle does not limit anything.
+type Throttle struct {
+	bytes *bucket
+	ops   *bucket
+}
+
+// New returns a Throttle for the given limits, where zero means unlimited,
+// or nil if neither is set.
+func New(bytesPerSecond int64, opsPerSecond int) *Throttle {
+	if bytesPerSecond <= 0 && opsPerSecond <= 0 {
+		return nil
+	}
+
+	t := &Throttle{}
+	if bytesPerSecond > 0 {
+		t.bytes = newBucket(float64(bytesPerSecond))
+	}
+	if opsPerSecond > 0 {
+		t.ops = newBucket(float64(opsPerSecond))
+	}
+	return t
+}
+
+// BeforeRead waits until another read operation is allowed.
+func (t *Throttle) BeforeRead(ctx context.Context) error {
+	if t == nil || t.ops == nil {
+		return nil
+	}
+	return t.ops.wait(ctx, 1)
+}
+
+// AfterRead accounts for n bytes read and waits until the rate is back
+// under the limit.
+func (t *Throttle) AfterRead(ctx context.Context, n int) error {
+	if t == nil || t.bytes == nil || n <= 0 {
+		return nil
+	}
+	return t.bytes.wait(ctx, float64(n))
+}
+
+// bucket is a token bucket shared by all readers. It holds at most one
+// second worth of tokens. Taking more tokens than are available puts the
+// bucket in debt, and the caller waits until the debt is paid off, so
+// requests larger than the bucket still get through at the set rate.
+type bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate float64) *bucket {
+	return &bucket{rate: rate, tokens: rate, last: time.Now()}
+}
+
+func (b *bucket) wait(ctx context.Context, n float64) error {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= n
+	delay := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}